@@ -2,18 +2,42 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const (
-	aria2AddURI = "aria2.addUri"
-	aria2Status = "aria2.tellStatus"
+	aria2AddURI              = "aria2.addUri"
+	aria2Status              = "aria2.tellStatus"
+	aria2TellActive          = "aria2.tellActive"
+	aria2TellWaiting         = "aria2.tellWaiting"
+	aria2TellStopped         = "aria2.tellStopped"
+	aria2Pause               = "aria2.pause"
+	aria2Unpause             = "aria2.unpause"
+	aria2Remove              = "aria2.remove"
+	aria2ForceRemove         = "aria2.forceRemove"
+	aria2ChangeOption        = "aria2.changeOption"
+	aria2GetGlobalStat       = "aria2.getGlobalStat"
+	aria2PurgeDownloadResult = "aria2.purgeDownloadResult"
+	aria2Multicall           = "system.multicall"
+)
+
+// aria2 download status
+const (
+	Aria2StatusActive   = "active"
+	Aria2StatusWaiting  = "waiting"
+	Aria2StatusPaused   = "paused"
+	Aria2StatusError    = "error"
+	Aria2StatusComplete = "complete"
+	Aria2StatusRemoved  = "removed"
 )
 
 type Aria2Client struct {
@@ -45,6 +69,25 @@ type Aria2Error struct {
 	Message string `json:"message"`
 }
 
+func (e *Aria2Error) Error() string {
+	return fmt.Sprintf("aria2 error, code: %d, message: %s", e.Code, e.Message)
+}
+
+// Aria2DownloadError is returned when aria2 stops a download before it completes
+type Aria2DownloadError struct {
+	Gid          string
+	Status       string
+	ErrorCode    string
+	ErrorMessage string
+}
+
+func (e *Aria2DownloadError) Error() string {
+	if e.ErrorMessage == "" {
+		return fmt.Sprintf("aria2 download %s is %s", e.Gid, e.Status)
+	}
+	return fmt.Sprintf("aria2 download %s is %s, code: %s, message: %s", e.Gid, e.Status, e.ErrorCode, e.ErrorMessage)
+}
+
 type Aria2StatusResult struct {
 	Bitfield        string                  `json:"bitfield"`
 	CompletedLength string                  `json:"completedLength"`
@@ -63,6 +106,22 @@ type Aria2StatusResult struct {
 	Files           []Aria2StatusResultFile `json:"files"`
 }
 
+// Progress converts the status into DownloadProgress
+func (r *Aria2StatusResult) Progress() DownloadProgress {
+	completed, _ := strconv.ParseInt(r.CompletedLength, 10, 64)
+	total, _ := strconv.ParseInt(r.TotalLength, 10, 64)
+	speed, _ := strconv.ParseInt(r.DownloadSpeed, 10, 64)
+	connections, _ := strconv.Atoi(r.Connections)
+	return DownloadProgress{
+		Gid:             r.Gid,
+		Status:          r.Status,
+		CompletedLength: completed,
+		TotalLength:     total,
+		DownloadSpeed:   speed,
+		Connections:     connections,
+	}
+}
+
 type Aria2StatusResultFile struct {
 	CompletedLength string                     `json:"completedLength"`
 	Index           string                     `json:"index"`
@@ -77,6 +136,35 @@ type Aria2StatusResultFileUri struct {
 	Uri    string `json:"uri"`
 }
 
+type Aria2GlobalStat struct {
+	DownloadSpeed   string `json:"downloadSpeed"`
+	UploadSpeed     string `json:"uploadSpeed"`
+	NumActive       string `json:"numActive"`
+	NumWaiting      string `json:"numWaiting"`
+	NumStopped      string `json:"numStopped"`
+	NumStoppedTotal string `json:"numStoppedTotal"`
+}
+
+// Aria2Call is one method call of system.multicall, the secret token is added to Params automatically
+type Aria2Call struct {
+	MethodName string        `json:"methodName"`
+	Params     []interface{} `json:"params"`
+}
+
+// Aria2MulticallResult is the result of one method call of system.multicall, either Result or Error is set
+type Aria2MulticallResult struct {
+	Result json.RawMessage
+	Error  *Aria2Error
+}
+
+// Decode decodes the result into v, or returns the error of the call
+func (r *Aria2MulticallResult) Decode(v interface{}) error {
+	if r.Error != nil {
+		return r.Error
+	}
+	return json.Unmarshal(r.Result, v)
+}
+
 func NewAria2Client(aria2Host, aria2Secret string, aria2Port int) *Aria2Client {
 	return &Aria2Client{
 		token:     aria2Secret,
@@ -84,19 +172,254 @@ func NewAria2Client(aria2Host, aria2Secret string, aria2Port int) *Aria2Client {
 	}
 }
 
-func (aria2Client *Aria2Client) DownloadFile(uri string, outDir, outFilename string) *Aria2Download {
+// DownloadFile asks aria2 to download uri into outDir/outFilename, the gid of the download is returned in Aria2Download
+func (aria2Client *Aria2Client) DownloadFile(uri string, outDir, outFilename string) (*Aria2Download, error) {
+	return aria2Client.DownloadFileContext(context.Background(), uri, outDir, outFilename)
+}
+
+// DownloadFileContext is like DownloadFile but binds the aria2 rpc request to ctx
+func (aria2Client *Aria2Client) DownloadFileContext(ctx context.Context, uri string, outDir, outFilename string) (*Aria2Download, error) {
 	payload := aria2Client.GenPayload4Download(aria2AddURI, uri, outDir, outFilename)
-	response, err := httpRequest(http.MethodPost, aria2Client.serverUrl, "", payload, nil)
-	if err != nil {
-		return nil
+	var gid string
+	if err := aria2Client.send(ctx, payload, &gid); err != nil {
+		return nil, err
+	}
+	return &Aria2Download{
+		Id:      payload.Id,
+		JsonRpc: payload.JsonRpc,
+		Gid:     gid,
+	}, nil
+}
+
+// TellStatus returns the status of the download denoted by gid, keys limits the returned fields
+func (aria2Client *Aria2Client) TellStatus(gid string, keys ...string) (*Aria2StatusResult, error) {
+	return aria2Client.TellStatusContext(context.Background(), gid, keys...)
+}
+
+// TellStatusContext is like TellStatus but binds the aria2 rpc request to ctx
+func (aria2Client *Aria2Client) TellStatusContext(ctx context.Context, gid string, keys ...string) (*Aria2StatusResult, error) {
+	var status Aria2StatusResult
+	if err := aria2Client.call(ctx, aria2Status, withKeys([]interface{}{gid}, keys), &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// TellActive returns the list of active downloads
+func (aria2Client *Aria2Client) TellActive(keys ...string) ([]Aria2StatusResult, error) {
+	return aria2Client.TellActiveContext(context.Background(), keys...)
+}
+
+// TellActiveContext is like TellActive but binds the aria2 rpc request to ctx
+func (aria2Client *Aria2Client) TellActiveContext(ctx context.Context, keys ...string) ([]Aria2StatusResult, error) {
+	var status []Aria2StatusResult
+	if err := aria2Client.call(ctx, aria2TellActive, withKeys(nil, keys), &status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// TellWaiting returns the list of waiting downloads, including paused ones
+func (aria2Client *Aria2Client) TellWaiting(offset, num int, keys ...string) ([]Aria2StatusResult, error) {
+	return aria2Client.TellWaitingContext(context.Background(), offset, num, keys...)
+}
+
+// TellWaitingContext is like TellWaiting but binds the aria2 rpc request to ctx
+func (aria2Client *Aria2Client) TellWaitingContext(ctx context.Context, offset, num int, keys ...string) ([]Aria2StatusResult, error) {
+	var status []Aria2StatusResult
+	if err := aria2Client.call(ctx, aria2TellWaiting, withKeys([]interface{}{offset, num}, keys), &status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// TellStopped returns the list of stopped downloads
+func (aria2Client *Aria2Client) TellStopped(offset, num int, keys ...string) ([]Aria2StatusResult, error) {
+	return aria2Client.TellStoppedContext(context.Background(), offset, num, keys...)
+}
+
+// TellStoppedContext is like TellStopped but binds the aria2 rpc request to ctx
+func (aria2Client *Aria2Client) TellStoppedContext(ctx context.Context, offset, num int, keys ...string) ([]Aria2StatusResult, error) {
+	var status []Aria2StatusResult
+	if err := aria2Client.call(ctx, aria2TellStopped, withKeys([]interface{}{offset, num}, keys), &status); err != nil {
+		return nil, err
 	}
+	return status, nil
+}
+
+// Pause pauses the download denoted by gid and returns its gid
+func (aria2Client *Aria2Client) Pause(gid string) (string, error) {
+	return aria2Client.PauseContext(context.Background(), gid)
+}
 
-	var aria2Download Aria2Download
-	err = json.Unmarshal(response, &aria2Download)
+// PauseContext is like Pause but binds the aria2 rpc request to ctx
+func (aria2Client *Aria2Client) PauseContext(ctx context.Context, gid string) (string, error) {
+	return aria2Client.callString(ctx, aria2Pause, gid)
+}
+
+// Unpause resumes the download denoted by gid and returns its gid
+func (aria2Client *Aria2Client) Unpause(gid string) (string, error) {
+	return aria2Client.UnpauseContext(context.Background(), gid)
+}
+
+// UnpauseContext is like Unpause but binds the aria2 rpc request to ctx
+func (aria2Client *Aria2Client) UnpauseContext(ctx context.Context, gid string) (string, error) {
+	return aria2Client.callString(ctx, aria2Unpause, gid)
+}
+
+// Remove removes the download denoted by gid and returns its gid
+func (aria2Client *Aria2Client) Remove(gid string) (string, error) {
+	return aria2Client.RemoveContext(context.Background(), gid)
+}
+
+// RemoveContext is like Remove but binds the aria2 rpc request to ctx
+func (aria2Client *Aria2Client) RemoveContext(ctx context.Context, gid string) (string, error) {
+	return aria2Client.callString(ctx, aria2Remove, gid)
+}
+
+// ForceRemove removes the download denoted by gid without any cleanup actions and returns its gid
+func (aria2Client *Aria2Client) ForceRemove(gid string) (string, error) {
+	return aria2Client.ForceRemoveContext(context.Background(), gid)
+}
+
+// ForceRemoveContext is like ForceRemove but binds the aria2 rpc request to ctx
+func (aria2Client *Aria2Client) ForceRemoveContext(ctx context.Context, gid string) (string, error) {
+	return aria2Client.callString(ctx, aria2ForceRemove, gid)
+}
+
+// ChangeOption changes the options of the download denoted by gid, aria2 returns OK on success
+func (aria2Client *Aria2Client) ChangeOption(gid string, options map[string]string) (string, error) {
+	return aria2Client.ChangeOptionContext(context.Background(), gid, options)
+}
+
+// ChangeOptionContext is like ChangeOption but binds the aria2 rpc request to ctx
+func (aria2Client *Aria2Client) ChangeOptionContext(ctx context.Context, gid string, options map[string]string) (string, error) {
+	return aria2Client.callString(ctx, aria2ChangeOption, gid, options)
+}
+
+// GetGlobalStat returns the global download and upload statistics
+func (aria2Client *Aria2Client) GetGlobalStat() (*Aria2GlobalStat, error) {
+	return aria2Client.GetGlobalStatContext(context.Background())
+}
+
+// GetGlobalStatContext is like GetGlobalStat but binds the aria2 rpc request to ctx
+func (aria2Client *Aria2Client) GetGlobalStatContext(ctx context.Context) (*Aria2GlobalStat, error) {
+	var stat Aria2GlobalStat
+	if err := aria2Client.call(ctx, aria2GetGlobalStat, nil, &stat); err != nil {
+		return nil, err
+	}
+	return &stat, nil
+}
+
+// PurgeDownloadResult purges completed, error and removed downloads from memory, aria2 returns OK on success
+func (aria2Client *Aria2Client) PurgeDownloadResult() (string, error) {
+	return aria2Client.PurgeDownloadResultContext(context.Background())
+}
+
+// PurgeDownloadResultContext is like PurgeDownloadResult but binds the aria2 rpc request to ctx
+func (aria2Client *Aria2Client) PurgeDownloadResultContext(ctx context.Context) (string, error) {
+	return aria2Client.callString(ctx, aria2PurgeDownloadResult)
+}
+
+// Multicall runs the calls in a single system.multicall request, the results are in the order of calls
+func (aria2Client *Aria2Client) Multicall(calls ...Aria2Call) ([]Aria2MulticallResult, error) {
+	return aria2Client.MulticallContext(context.Background(), calls...)
+}
+
+// MulticallContext is like Multicall but binds the aria2 rpc request to ctx
+func (aria2Client *Aria2Client) MulticallContext(ctx context.Context, calls ...Aria2Call) ([]Aria2MulticallResult, error) {
+	methods := make([]Aria2Call, 0, len(calls))
+	for _, call := range calls {
+		methods = append(methods, Aria2Call{
+			MethodName: call.MethodName,
+			Params:     append([]interface{}{"token:" + aria2Client.token}, call.Params...),
+		})
+	}
+
+	// system.multicall itself takes no token
+	var raws []json.RawMessage
+	payload := &Aria2Payload{
+		JsonRpc: "2.0",
+		Id:      aria2Multicall,
+		Method:  aria2Multicall,
+		Params:  []interface{}{methods},
+	}
+	if err := aria2Client.send(ctx, payload, &raws); err != nil {
+		return nil, err
+	}
+
+	// each result is either a one element array of the result or an error struct
+	results := make([]Aria2MulticallResult, len(raws))
+	for i, raw := range raws {
+		raw = bytes.TrimSpace(raw)
+		if len(raw) > 0 && raw[0] == '[' {
+			var wrapped []json.RawMessage
+			if err := json.Unmarshal(raw, &wrapped); err != nil {
+				return nil, err
+			}
+			if len(wrapped) > 0 {
+				results[i].Result = wrapped[0]
+			}
+			continue
+		}
+		var aria2Err Aria2Error
+		if err := json.Unmarshal(raw, &aria2Err); err != nil {
+			return nil, err
+		}
+		results[i].Error = &aria2Err
+	}
+	return results, nil
+}
+
+func (aria2Client *Aria2Client) callString(ctx context.Context, method string, params ...interface{}) (string, error) {
+	var result string
+	if err := aria2Client.call(ctx, method, params, &result); err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+// call sends the aria2 rpc request with the secret token and decodes the result into result
+func (aria2Client *Aria2Client) call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	return aria2Client.send(ctx, &Aria2Payload{
+		JsonRpc: "2.0",
+		Id:      method,
+		Method:  method,
+		Params:  append([]interface{}{"token:" + aria2Client.token}, params...),
+	}, result)
+}
+
+// send posts the payload to aria2 and decodes the result into result
+func (aria2Client *Aria2Client) send(ctx context.Context, payload *Aria2Payload, result interface{}) error {
+	response, err := httpRequest(ctx, http.MethodPost, aria2Client.serverUrl, "", payload, nil)
 	if err != nil {
-		return nil
+		// aria2 answers rpc errors with http status 400 and the error in the body
+		var statusErr *httpStatusError
+		if !errors.As(err, &statusErr) || len(statusErr.Body) == 0 {
+			return err
+		}
+		response = statusErr.Body
+	}
+
+	var res struct {
+		Error  *Aria2Error     `json:"error"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err = json.Unmarshal(response, &res); err != nil {
+		return err
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	return json.Unmarshal(res.Result, result)
+}
+
+// withKeys appends keys to params if any, aria2 returns all the fields without keys
+func withKeys(params []interface{}, keys []string) []interface{} {
+	if len(keys) > 0 {
+		params = append(params, keys)
 	}
-	return &aria2Download
+	return params
 }
 
 func (aria2Client *Aria2Client) GenPayload4Download(method string, uri string, outDir, outFilename string) *Aria2Payload {
@@ -112,12 +435,12 @@ func (aria2Client *Aria2Client) GenPayload4Download(method string, uri string, o
 	}
 }
 
-func httpRequest(httpMethod, uri, tokenString string, params interface{}, timeoutSecond *int) (body []byte, err error) {
+func httpRequest(ctx context.Context, httpMethod, uri, tokenString string, params interface{}, timeoutSecond *int) (body []byte, err error) {
 	var request *http.Request
 
 	switch params := params.(type) {
 	case io.Reader:
-		request, err = http.NewRequest(httpMethod, uri, params)
+		request, err = http.NewRequestWithContext(ctx, httpMethod, uri, params)
 		if err != nil {
 			return nil, err
 		}
@@ -128,7 +451,7 @@ func httpRequest(httpMethod, uri, tokenString string, params interface{}, timeou
 			return nil, errJson
 		}
 
-		request, err = http.NewRequest(httpMethod, uri, bytes.NewBuffer(jsonReq))
+		request, err = http.NewRequestWithContext(ctx, httpMethod, uri, bytes.NewBuffer(jsonReq))
 		if err != nil {
 			return nil, err
 		}
@@ -154,7 +477,7 @@ func httpRequest(httpMethod, uri, tokenString string, params interface{}, timeou
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("http status: %s, code:%d, url:%s", response.Status, response.StatusCode, uri)
+		return nil, newHttpStatusError(response, uri)
 	}
 
 	return io.ReadAll(response.Body)
@@ -0,0 +1,417 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// directory entry types
+const (
+	EntryTypeFile      = "file"
+	EntryTypeDirectory = "directory"
+	EntryTypeSymlink   = "symlink"
+)
+
+const (
+	contentTypeRaw = "application/vnd.ipld.raw"
+	// maxBlockSize limits the blocks fetched from a gateway
+	maxBlockSize = 4 << 20
+	// blockPeekSize covers the unixfs type and data length at the start of a block without links
+	blockPeekSize = 32
+	// listConcurrency limits the entries looked up at once while listing from a gateway
+	listConcurrency = 8
+)
+
+// DirEntry is an entry of a directory listed by ListDirectory or Walk
+type DirEntry struct {
+	Name string
+	Path string // slash separated path under the ipfs cid
+	Cid  string
+	Type string // file, directory or symlink
+	Size int64  // file size, 0 for directories and symlinks
+}
+
+// ListDirectory lists the entries of the directory at the slash separated dirPath under ipfsCid,
+// an empty dirPath lists the root. The entries are listed with the ipfs api, and with the gateways
+// if no ipfs api is configured or reachable.
+func (m *MetaClient) ListDirectory(ipfsCid, dirPath string) ([]DirEntry, error) {
+	return m.ListDirectoryContext(context.Background(), ipfsCid, dirPath)
+}
+
+// ListDirectoryContext is like ListDirectory but binds the ipfs and gateway requests to ctx
+func (m *MetaClient) ListDirectoryContext(ctx context.Context, ipfsCid, dirPath string) ([]DirEntry, error) {
+	dirPath = strings.Trim(path.Clean("/"+dirPath), "/")
+	if len(m.conf.ipfsApis()) > 0 {
+		entries, err := m.listFromIpfsApi(ctx, ipfsCid, dirPath)
+		if err == nil || !isIpfsNodeError(ctx, err) {
+			return entries, err
+		}
+	}
+
+	var gateways []string
+	if m.conf != nil {
+		gateways = append(append(gateways, m.conf.IpfsGateway), m.conf.PublicGateways...)
+	}
+	err := errors.New("ipfs api or gateway is required")
+	for _, gateway := range gateways {
+		if gateway == "" {
+			continue
+		}
+		var entries []DirEntry
+		if entries, err = listFromGateway(ctx, http.DefaultClient, gateway, ipfsCid, dirPath); err == nil {
+			return entries, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return nil, err
+}
+
+// Walk calls fn for every entry under the directory at dirPath of ipfsCid, directories before their entries.
+// If fn returns fs.SkipDir for a directory, its entries are skipped, for another entry the rest of its directory is skipped.
+func (m *MetaClient) Walk(ipfsCid, dirPath string, fn func(entry DirEntry) error) error {
+	return m.WalkContext(context.Background(), ipfsCid, dirPath, fn)
+}
+
+// WalkContext is like Walk but binds the ipfs and gateway requests to ctx
+func (m *MetaClient) WalkContext(ctx context.Context, ipfsCid, dirPath string, fn func(entry DirEntry) error) error {
+	err := m.walk(ctx, ipfsCid, dirPath, fn)
+	if err == fs.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func (m *MetaClient) walk(ctx context.Context, ipfsCid, dirPath string, fn func(entry DirEntry) error) error {
+	entries, err := m.ListDirectoryContext(ctx, ipfsCid, dirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err = fn(entry)
+		if err == nil && entry.Type == EntryTypeDirectory {
+			err = m.walk(ctx, ipfsCid, entry.Path, fn)
+		}
+		if err == fs.SkipDir && entry.Type == EntryTypeDirectory {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listFromIpfsApi lists the directory with the `ls` command of the ipfs api. The type is checked first,
+// ls of a file does not fail but lists its chunks.
+func (m *MetaClient) listFromIpfsApi(ctx context.Context, ipfsCid, dirPath string) (entries []DirEntry, err error) {
+	err = m.withIpfsFailover(ctx, func(node *ipfsNode) error {
+		stat, err := node.sh.FilesStat(ctx, PathJoin("/ipfs/", ipfsCid, dirPath))
+		if err != nil {
+			return err
+		}
+		if stat.Type != EntryTypeDirectory {
+			return notDirectoryError(ipfsCid, dirPath)
+		}
+
+		var out struct {
+			Objects []struct {
+				Hash  string
+				Links []struct {
+					Name string
+					Hash string
+					Size uint64
+					Type int
+				}
+			}
+		}
+		err = node.sh.Request("ls", PathJoin("/ipfs/", ipfsCid, dirPath)).
+			Option("resolve-type", true).
+			Option("size", true).
+			Exec(ctx, &out)
+		if err != nil {
+			return err
+		}
+		entries = nil
+		for _, object := range out.Objects {
+			for _, link := range object.Links {
+				entry := DirEntry{Name: link.Name, Path: path.Join(dirPath, link.Name), Cid: link.Hash}
+				switch link.Type {
+				case unixfsDirectory, unixfsHAMTShard:
+					entry.Type = EntryTypeDirectory
+				case unixfsSymlink:
+					entry.Type = EntryTypeSymlink
+				default:
+					entry.Type, entry.Size = EntryTypeFile, int64(link.Size)
+				}
+				entries = append(entries, entry)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// notDirectoryError is returned for listing a path which is not a directory
+func notDirectoryError(ipfsCid, dirPath string) error {
+	return fmt.Errorf("%s is not a directory", path.Join(ipfsCid, dirPath))
+}
+
+// listFromGateway lists the directory from the blocks fetched with ?format=raw, each one is verified
+// against its cid. The sizes of raw entries are the sizes of their links, the types and sizes of the
+// other entries are looked up with dagPbEntryInfo.
+func listFromGateway(ctx context.Context, client *http.Client, gateway, ipfsCid, dirPath string) ([]DirEntry, error) {
+	root, err := cid.Decode(ipfsCid)
+	if err != nil {
+		return nil, err
+	}
+	get := func(c cid.Cid) ([]byte, error) {
+		return fetchBlock(ctx, client, gateway, c)
+	}
+	dir, err := resolveUnixfsPath(get, root, dirPath)
+	if err != nil {
+		return nil, err
+	}
+	if dir.Type() != cid.DagProtobuf {
+		return nil, notDirectoryError(ipfsCid, dirPath)
+	}
+	block, err := get(dir)
+	if err != nil {
+		return nil, err
+	}
+	node, err := decodePBNode(block)
+	if err != nil {
+		return nil, err
+	}
+	fsData, err := decodeUnixfs(node.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var links []pbLink
+	switch fsData.Type {
+	case unixfsDirectory:
+		links = node.Links
+	case unixfsHAMTShard:
+		if links, err = shardLinks(get, node); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, notDirectoryError(ipfsCid, dirPath)
+	}
+
+	entries := make([]DirEntry, len(links))
+	errs := make([]error, len(links))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < listConcurrency && w < len(links); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = dagPbEntryInfo(ctx, client, gateway, &entries[i], links[i].Hash)
+			}
+		}()
+	}
+	for i, link := range links {
+		entries[i] = DirEntry{Name: link.Name, Path: path.Join(dirPath, link.Name), Cid: link.Hash.String(), Type: EntryTypeFile}
+		if link.Hash.Type() == cid.Raw {
+			entries[i].Size = int64(link.Tsize)
+			continue
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// dagPbEntryInfo sets the type and size of the dag-pb entry. A block without links starts with them, e.g. a file
+// of one chunk, so only its first bytes are fetched, which cannot be verified. The root block of another entry
+// only holds the links, it is fetched whole and verified.
+func dagPbEntryInfo(ctx context.Context, client *http.Client, gateway string, entry *DirEntry, c cid.Cid) error {
+	block, complete, err := requestBlock(ctx, client, gateway, c, blockPeekSize)
+	if err != nil {
+		return err
+	}
+	if !complete {
+		if typ, size, ok := peekUnixfs(block); ok {
+			setEntryType(entry, typ, size)
+			return nil
+		}
+		if block, err = fetchBlock(ctx, client, gateway, c); err != nil {
+			return err
+		}
+	}
+	node, err := decodePBNode(block)
+	if err != nil {
+		return err
+	}
+	fsData, err := decodeUnixfs(node.Data)
+	if err != nil {
+		return err
+	}
+	setEntryType(entry, fsData.Type, fsData.FileSize)
+	return nil
+}
+
+func setEntryType(entry *DirEntry, typ int, size uint64) {
+	switch typ {
+	case unixfsDirectory, unixfsHAMTShard:
+		entry.Type = EntryTypeDirectory
+	case unixfsSymlink:
+		entry.Type = EntryTypeSymlink
+	default:
+		entry.Type, entry.Size = EntryTypeFile, int64(size)
+	}
+}
+
+// peekUnixfs reads the unixfs type and size from the first bytes of a dag-pb block, ok is false
+// if the block starts with links, which are encoded before the data, or the bytes are not enough
+func peekUnixfs(prefix []byte) (typ int, size uint64, ok bool) {
+	if len(prefix) == 0 || prefix[0] != 1<<3|2 {
+		return 0, 0, false
+	}
+	dataLen, n := binary.Uvarint(prefix[1:])
+	if n <= 0 {
+		return 0, 0, false
+	}
+	buf := prefix[1+n:]
+	complete := uint64(len(buf)) >= dataLen
+	if complete {
+		buf = buf[:dataLen]
+	}
+
+	hasType, hasSize := false, false
+	for len(buf) > 0 {
+		key, n := binary.Uvarint(buf)
+		if n <= 0 {
+			break
+		}
+		v, m := binary.Uvarint(buf[n:])
+		if m <= 0 {
+			break
+		}
+		buf = buf[n+m:]
+		switch key {
+		case 1<<3 | 0:
+			typ, hasType = int(v), true
+		case 3<<3 | 0:
+			size, hasSize = v, true
+		case 2<<3 | 2:
+			// the data of a block without links is the whole file
+			size, hasSize = v, true
+		}
+		if key&7 == 2 {
+			if uint64(len(buf)) < v {
+				break
+			}
+			buf = buf[v:]
+		}
+	}
+	if !hasType {
+		return 0, 0, false
+	}
+	if (typ == unixfsFile || typ == unixfsRaw) && !hasSize && !complete {
+		return 0, 0, false
+	}
+	return typ, size, true
+}
+
+// shardLinks returns the entries of a sharded directory, without the bucket prefixes of the names
+func shardLinks(get func(cid.Cid) ([]byte, error), node *pbNode) ([]pbLink, error) {
+	var links []pbLink
+	for _, link := range node.Links {
+		if len(link.Name) < 2 {
+			return nil, fmt.Errorf("invalid shard link name %q", link.Name)
+		}
+		if len(link.Name) > 2 {
+			link.Name = link.Name[2:]
+			links = append(links, link)
+			continue
+		}
+		block, err := get(link.Hash)
+		if err != nil {
+			return nil, err
+		}
+		shard, err := decodePBNode(block)
+		if err != nil {
+			return nil, err
+		}
+		sub, err := shardLinks(get, shard)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, sub...)
+	}
+	return links, nil
+}
+
+// fetchBlock gets the block from the gateway with ?format=raw and verifies it against its cid
+func fetchBlock(ctx context.Context, client *http.Client, gateway string, c cid.Cid) ([]byte, error) {
+	block, _, err := requestBlock(ctx, client, gateway, c, 0)
+	return block, err
+}
+
+// requestBlock gets the block from the gateway with ?format=raw, or its first peek bytes with a ranged request
+// if peek is positive. A whole block is verified against its cid, and complete is true.
+func requestBlock(ctx context.Context, client *http.Client, gateway string, c cid.Cid, peek int) (block []byte, complete bool, err error) {
+	// the data of an identity cid is inlined
+	if decoded, err := mh.Decode(c.Hash()); err == nil && decoded.Code == mh.IDENTITY {
+		return decoded.Digest, true, nil
+	}
+	blockUrl := PathJoin(gateway, "ipfs", c.String()) + "?format=raw"
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, blockUrl, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	request.Header.Set("Accept", contentTypeRaw)
+	if peek > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=0-%d", peek-1))
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, false, err
+	}
+	defer response.Body.Close()
+	switch {
+	case response.StatusCode == http.StatusPartialContent && peek > 0:
+		block, err = io.ReadAll(io.LimitReader(response.Body, int64(peek)))
+		return block, false, err
+	case response.StatusCode != http.StatusOK:
+		return nil, false, newHttpStatusError(response, blockUrl)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxBlockSize+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) > maxBlockSize {
+		return nil, false, fmt.Errorf("block %s is larger than %d bytes", c, maxBlockSize)
+	}
+	actual, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, false, err
+	}
+	if !actual.Equals(c) {
+		return nil, false, fmt.Errorf("%w: %s", ErrBlockMismatch, c)
+	}
+	return data, true, nil
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// serveBlocks serves the blocks to ?format=raw requests of a gateway
+func serveBlocks(t *testing.T, blocks []carBlock) *httptest.Server {
+	store := map[string][]byte{}
+	for _, block := range blocks {
+		store[block.cid.String()] = block.data
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := store[strings.TrimPrefix(r.URL.Path, "/ipfs/")]
+		if !ok || r.URL.Query().Get("format") != "raw" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newFakeIpfsApi answers files/stat with the types of the paths, and ls of any path with the chunks of a file
+func newFakeIpfsApi(t *testing.T, types map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arg := r.URL.Query().Get("arg")
+		switch r.URL.Path {
+		case "/api/v0/files/stat":
+			typ, ok := types[arg]
+			if !ok {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]interface{}{"Message": "file does not exist", "Code": 0, "Type": "error"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"Hash": "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH", "Type": typ})
+		case "/api/v0/ls":
+			links := []map[string]interface{}{{"Name": "", "Hash": "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH", "Size": 4, "Type": 2}}
+			json.NewEncoder(w).Encode(map[string]interface{}{"Objects": []map[string]interface{}{{"Hash": arg, "Links": links}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestListDirectoryNotDirectory(t *testing.T) {
+	root, blocks := testDag(t, testTree)
+	gateway := serveBlocks(t, blocks)
+	ipfsApi := newFakeIpfsApi(t, map[string]string{
+		"/ipfs/" + root.String():            EntryTypeDirectory,
+		"/ipfs/" + root.String() + "/a.txt": EntryTypeFile,
+	})
+	want := notDirectoryError(root.String(), "a.txt").Error()
+
+	tests := []struct {
+		name string
+		conf *MetaConf
+	}{
+		{name: "ipfs api", conf: &MetaConf{IpfsApi: ipfsApi.URL}},
+		{name: "gateway", conf: &MetaConf{IpfsGateway: gateway.URL}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := NewClient("key", "token", tt.conf).ListDirectory(root.String(), "a.txt")
+			if err == nil || err.Error() != want {
+				t.Errorf("entries %+v, err = %v, want %q", entries, err, want)
+			}
+		})
+	}
+}
+
+func TestPeekUnixfs(t *testing.T) {
+	size := uint64(defaultMaxLinks * defaultChunkSize)
+	blockSizes := make([]uint64, defaultMaxLinks)
+	for i := range blockSizes {
+		blockSizes[i] = defaultChunkSize
+	}
+	fileNode := encodePBNode(nil, encodeUnixfs(unixfsFile, nil, &size, blockSizes))
+	leafSize := uint64(11)
+	leafNode := encodePBNode(nil, encodeUnixfs(unixfsFile, []byte("hello world"), &leafSize, nil))
+	dirNode := encodePBNode(nil, encodeUnixfs(unixfsDirectory, nil, nil, nil))
+
+	tests := []struct {
+		name     string
+		prefix   []byte
+		wantType int
+		wantSize uint64
+		wantOk   bool
+	}{
+		{"file", fileNode, unixfsFile, size, true},
+		{"file peek", fileNode[:blockPeekSize], unixfsFile, size, true},
+		{"leaf", leafNode, unixfsFile, 11, true},
+		{"directory", dirNode, unixfsDirectory, 0, true},
+		{"links first", encodePBNode([]dagLink{{Node: dagNode{Cid: cid.MustParse("QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH")}}}, nil), 0, 0, false},
+		{"empty", nil, 0, 0, false},
+		{"type only", fileNode[:4], 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			typ, size, ok := peekUnixfs(tt.prefix)
+			if ok != tt.wantOk || (ok && (typ != tt.wantType || size != tt.wantSize)) {
+				t.Errorf("peek = %d, %d, %v, want %d, %d, %v", typ, size, ok, tt.wantType, tt.wantSize, tt.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,405 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+const (
+	contentTypeCar = "application/vnd.ipld.car"
+	// blocks of ipfs are at most 2MiB, larger sections are rejected
+	maxCarSectionSize = 4 << 20
+)
+
+var ErrBlockMismatch = errors.New("block does not match its cid")
+
+// carReader reads the blocks of a CARv1 stream and verifies each one against its cid
+type carReader struct {
+	r *bufio.Reader
+}
+
+func newCarReader(r io.Reader) (*carReader, error) {
+	br := bufio.NewReader(r)
+	length, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 || length > maxCarSectionSize {
+		return nil, fmt.Errorf("invalid car header length %d", length)
+	}
+	// the header only names the roots, the blocks are verified from the requested root instead
+	if _, err = io.CopyN(io.Discard, br, int64(length)); err != nil {
+		return nil, err
+	}
+	return &carReader{r: br}, nil
+}
+
+// next returns the next verified block, or io.EOF at the end of the stream
+func (cr *carReader) next() (cid.Cid, []byte, error) {
+	length, err := binary.ReadUvarint(cr.r)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	if length == 0 || length > maxCarSectionSize {
+		return cid.Undef, nil, fmt.Errorf("invalid car section length %d", length)
+	}
+	section := make([]byte, length)
+	if _, err = io.ReadFull(cr.r, section); err != nil {
+		return cid.Undef, nil, err
+	}
+
+	n, c, err := cid.CidFromBytes(section)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	data := section[n:]
+	actual, err := c.Prefix().Sum(data)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	if !actual.Equals(c) {
+		return cid.Undef, nil, fmt.Errorf("%w: %s", ErrBlockMismatch, c)
+	}
+	return c, data, nil
+}
+
+// blockStore keeps the verified blocks in a temporary directory
+type blockStore struct {
+	dir string
+}
+
+func (bs *blockStore) put(c cid.Cid, data []byte) error {
+	return os.WriteFile(filepath.Join(bs.dir, c.Hash().B58String()), data, 0644)
+}
+
+func (bs *blockStore) get(c cid.Cid) ([]byte, error) {
+	if c.Prefix().MhType == mh.IDENTITY {
+		decoded, err := mh.Decode(c.Hash())
+		if err != nil {
+			return nil, err
+		}
+		return decoded.Digest, nil
+	}
+	data, err := os.ReadFile(filepath.Join(bs.dir, c.Hash().B58String()))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("block %s is missing from the car", c)
+	}
+	return data, err
+}
+
+// resolveUnixfsPath walks the slash separated path from root through the directories of the blocks from get
+func resolveUnixfsPath(get func(cid.Cid) ([]byte, error), root cid.Cid, p string) (cid.Cid, error) {
+	for _, name := range strings.Split(p, "/") {
+		if name == "" {
+			continue
+		}
+		block, err := get(root)
+		if err != nil {
+			return cid.Undef, err
+		}
+		if root.Type() != cid.DagProtobuf {
+			return cid.Undef, fmt.Errorf("%s is not a directory", root)
+		}
+		node, err := decodePBNode(block)
+		if err != nil {
+			return cid.Undef, err
+		}
+		fs, err := decodeUnixfs(node.Data)
+		if err != nil {
+			return cid.Undef, err
+		}
+		var found bool
+		switch fs.Type {
+		case unixfsDirectory:
+			for _, link := range node.Links {
+				if link.Name == name {
+					root, found = link.Hash, true
+					break
+				}
+			}
+		case unixfsHAMTShard:
+			root, found = findInShard(get, node, name)
+		default:
+			return cid.Undef, fmt.Errorf("%s is not a directory", root)
+		}
+		if !found {
+			return cid.Undef, fmt.Errorf("%s is not found in %s", name, root)
+		}
+	}
+	return root, nil
+}
+
+// findInShard looks for the entry in the sharded directory, the sub shards which cannot be got are skipped,
+// as a CAR only has the ones on the path
+func findInShard(get func(cid.Cid) ([]byte, error), node *pbNode, name string) (cid.Cid, bool) {
+	for _, link := range node.Links {
+		if len(link.Name) > 2 {
+			if link.Name[2:] == name {
+				return link.Hash, true
+			}
+			continue
+		}
+		block, err := get(link.Hash)
+		if err != nil {
+			continue
+		}
+		if shard, err := decodePBNode(block); err == nil {
+			if c, ok := findInShard(get, shard, name); ok {
+				return c, true
+			}
+		}
+	}
+	return cid.Undef, false
+}
+
+// downloadCar retrieves the root from the gateway url as a CAR, verifies every block,
+// and writes the file or directory tree of the root, or of subPath under it, to target.
+// The gateway url should include subPath, so that the CAR has the blocks of the path.
+func downloadCar(ctx context.Context, client *http.Client, gatewayUrl string, root cid.Cid, subPath string, target string, progress func(DownloadProgress)) error {
+	carUrl, err := carRequestUrl(gatewayUrl)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, carUrl, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", contentTypeCar)
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return newHttpStatusError(response, carUrl)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	blocksDir, err := os.MkdirTemp(filepath.Dir(target), "."+filepath.Base(target)+".blocks-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(blocksDir)
+	store := &blockStore{dir: blocksDir}
+
+	var completed int64
+	cr, err := newCarReader(&countingReader{r: response.Body, n: &completed})
+	if err != nil {
+		return err
+	}
+	lastReport := time.Now()
+	for {
+		c, data, err := cr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err = store.put(c, data); err != nil {
+			return err
+		}
+		if progress != nil && time.Since(lastReport) >= time.Second {
+			lastReport = time.Now()
+			progress(DownloadProgress{Status: Aria2StatusActive, CompletedLength: completed, Connections: 1})
+		}
+	}
+
+	// the sub path is resolved through the verified blocks, not trusting the gateway
+	if root, err = resolveUnixfsPath(store.get, root, subPath); err != nil {
+		return err
+	}
+
+	// write into a temporary path, so that target only appears once it is complete
+	partial := filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+partSuffix)
+	os.RemoveAll(partial)
+	if err = (&unixfsWriter{store: store}).write(root, partial); err != nil {
+		os.RemoveAll(partial)
+		return err
+	}
+	os.RemoveAll(target)
+	if err = os.Rename(partial, target); err != nil {
+		return err
+	}
+	if progress != nil {
+		progress(DownloadProgress{Status: Aria2StatusComplete, CompletedLength: completed})
+	}
+	return nil
+}
+
+// carRequestUrl asks the gateway for the whole dag of the path as a CAR
+func carRequestUrl(gatewayUrl string) (string, error) {
+	u, err := url.Parse(gatewayUrl)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Del("filename")
+	query.Set("format", "car")
+	query.Set("dag-scope", "all")
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// unixfsWriter writes UnixFS dags from the block store to the local file system
+type unixfsWriter struct {
+	store *blockStore
+}
+
+func (w *unixfsWriter) write(c cid.Cid, target string) error {
+	block, err := w.store.get(c)
+	if err != nil {
+		return err
+	}
+	if c.Type() == cid.Raw {
+		file, err := createFile(target)
+		if err != nil {
+			return err
+		}
+		if _, err = file.Write(block); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
+	if c.Type() != cid.DagProtobuf {
+		return fmt.Errorf("unsupported codec of %s", c)
+	}
+
+	node, err := decodePBNode(block)
+	if err != nil {
+		return err
+	}
+	fs, err := decodeUnixfs(node.Data)
+	if err != nil {
+		return err
+	}
+
+	switch fs.Type {
+	case unixfsFile, unixfsRaw:
+		file, err := createFile(target)
+		if err != nil {
+			return err
+		}
+		if err = w.writeFile(node, fs, file); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	case unixfsDirectory:
+		if err = os.Mkdir(target, os.ModePerm); err != nil {
+			return err
+		}
+		for _, link := range node.Links {
+			if err = checkEntryName(link.Name); err != nil {
+				return err
+			}
+			if err = w.write(link.Hash, filepath.Join(target, link.Name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case unixfsHAMTShard:
+		if err = os.Mkdir(target, os.ModePerm); err != nil {
+			return err
+		}
+		return w.writeShard(node, target)
+	case unixfsSymlink:
+		return os.Symlink(string(fs.Data), target)
+	default:
+		return fmt.Errorf("unsupported unixfs type %d of %s", fs.Type, c)
+	}
+}
+
+// createFile creates the file which must not exist, the tree is written into a new directory,
+// so an existing path is a duplicate entry, which may be a symlink to write through
+func createFile(target string) (*os.File, error) {
+	return os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+}
+
+// writeFile writes the data of the file node and its children in order
+func (w *unixfsWriter) writeFile(node *pbNode, fs *unixfsData, out io.Writer) error {
+	if _, err := out.Write(fs.Data); err != nil {
+		return err
+	}
+	for _, link := range node.Links {
+		block, err := w.store.get(link.Hash)
+		if err != nil {
+			return err
+		}
+		if link.Hash.Type() == cid.Raw {
+			if _, err = out.Write(block); err != nil {
+				return err
+			}
+			continue
+		}
+		child, err := decodePBNode(block)
+		if err != nil {
+			return err
+		}
+		childFs, err := decodeUnixfs(child.Data)
+		if err != nil {
+			return err
+		}
+		if err = w.writeFile(child, childFs, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeShard writes the entries of a sharded directory, the link names are prefixed
+// by the two hex digits of the bucket, links with only the prefix are sub shards
+func (w *unixfsWriter) writeShard(node *pbNode, target string) error {
+	for _, link := range node.Links {
+		if len(link.Name) < 2 {
+			return fmt.Errorf("invalid shard link name %q", link.Name)
+		}
+		if len(link.Name) > 2 {
+			name := link.Name[2:]
+			if err := checkEntryName(name); err != nil {
+				return err
+			}
+			if err := w.write(link.Hash, filepath.Join(target, name)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		block, err := w.store.get(link.Hash)
+		if err != nil {
+			return err
+		}
+		shard, err := decodePBNode(block)
+		if err != nil {
+			return err
+		}
+		if err = w.writeShard(shard, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkEntryName rejects directory entry names which would escape the directory
+func checkEntryName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return fmt.Errorf("invalid directory entry name %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+// carBlock is a section of a test CAR stream
+type carBlock struct {
+	cid  cid.Cid
+	data []byte
+}
+
+// encodeCar encodes the blocks as a CARv1 stream, the header is not parsed by carReader
+func encodeCar(blocks []carBlock) []byte {
+	header := []byte("header")
+	buf := binary.AppendUvarint(nil, uint64(len(header)))
+	buf = append(buf, header...)
+	for _, block := range blocks {
+		cidBytes := block.cid.Bytes()
+		buf = binary.AppendUvarint(buf, uint64(len(cidBytes)+len(block.data)))
+		buf = append(buf, cidBytes...)
+		buf = append(buf, block.data...)
+	}
+	return buf
+}
+
+// testDag builds the DAG with build and returns its root and blocks
+func testDag(t *testing.T, build func(b *dagBuilder) (dagNode, error)) (cid.Cid, []carBlock) {
+	t.Helper()
+	var blocks []carBlock
+	b := newDagBuilder(testParams(func(p *unixfsParams) { p.ChunkSize = 4 }))
+	b.put = func(c cid.Cid, block []byte) error {
+		blocks = append(blocks, carBlock{cid: c, data: block})
+		return nil
+	}
+	root, err := build(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root.Cid, blocks
+}
+
+func testFile(b *dagBuilder, content string) dagNode {
+	node, err := b.file(strings.NewReader(content))
+	if err != nil {
+		panic(err)
+	}
+	return node
+}
+
+// testTree is a directory with a.txt and sub/b.txt
+func testTree(b *dagBuilder) (dagNode, error) {
+	sub, err := b.directory([]dagLink{{Name: "b.txt", Node: testFile(b, "bbbbbbbbbb")}})
+	if err != nil {
+		return dagNode{}, err
+	}
+	return b.directory([]dagLink{
+		{Name: "a.txt", Node: testFile(b, "hello world")},
+		{Name: "sub", Node: sub},
+	})
+}
+
+func TestCarReader(t *testing.T) {
+	_, blocks := testDag(t, testTree)
+	tampered := append([]carBlock{}, blocks...)
+	tampered[0].data = append([]byte{}, tampered[0].data...)
+	tampered[0].data[0] ^= 0xff
+
+	oversize := encodeCar(nil)
+	oversize = binary.AppendUvarint(oversize, maxCarSectionSize+1)
+
+	tests := []struct {
+		name    string
+		stream  []byte
+		wantErr error // nil expects every block
+	}{
+		{name: "valid", stream: encodeCar(blocks)},
+		{name: "tampered block", stream: encodeCar(tampered), wantErr: ErrBlockMismatch},
+		{name: "oversize section", stream: oversize, wantErr: errors.New("invalid car section length")},
+		{name: "truncated section", stream: encodeCar(blocks)[:len(encodeCar(blocks))-1], wantErr: errors.New("unexpected EOF")},
+		{name: "empty header", stream: []byte{0}, wantErr: errors.New("invalid car header length")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var count int
+			cr, err := newCarReader(bytes.NewReader(tt.stream))
+			for err == nil {
+				if _, _, err = cr.next(); err == nil {
+					count++
+				}
+			}
+			if tt.wantErr == nil {
+				if err != io.EOF || count != len(blocks) {
+					t.Errorf("read %d blocks, err = %v, want %d blocks", count, err, len(blocks))
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) && !strings.Contains(err.Error(), tt.wantErr.Error()) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveUnixfsPath(t *testing.T) {
+	root, blocks := testDag(t, testTree)
+	store := map[cid.Cid][]byte{}
+	for _, block := range blocks {
+		store[block.cid] = block.data
+	}
+	get := func(c cid.Cid) ([]byte, error) {
+		if data, ok := store[c]; ok {
+			return data, nil
+		}
+		return nil, errors.New("missing")
+	}
+
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{path: ""},
+		{path: "sub"},
+		{path: "/sub/b.txt/"},
+		{path: "missing", wantErr: true},
+		{path: "a.txt/b", wantErr: true},
+	}
+	for _, tt := range tests {
+		c, err := resolveUnixfsPath(get, root, tt.path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: err = %v, want error %v", tt.path, err, tt.wantErr)
+		}
+		if err == nil {
+			if _, ok := store[c]; !ok {
+				t.Errorf("%q: resolved to unknown %s", tt.path, c)
+			}
+		}
+	}
+}
+
+func TestCarRequestUrl(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"https://gw.example/ipfs/Qm/sub", "https://gw.example/ipfs/Qm/sub?dag-scope=all&format=car"},
+		{"https://gw.example/ipfs/Qm?filename=a.txt&x=1", "https://gw.example/ipfs/Qm?dag-scope=all&format=car&x=1"},
+	}
+	for _, tt := range tests {
+		got, err := carRequestUrl(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("carRequestUrl(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+// serveCar serves the stream to CAR requests
+func serveCar(t *testing.T, stream []byte) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("format") != "car" || r.Header.Get("Accept") != contentTypeCar {
+			http.Error(w, "not a car request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", contentTypeCar)
+		w.Write(stream)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDownloadCar(t *testing.T) {
+	root, blocks := testDag(t, testTree)
+	server := serveCar(t, encodeCar(blocks))
+	dir := t.TempDir()
+
+	target := filepath.Join(dir, "tree")
+	if err := downloadCar(context.Background(), server.Client(), server.URL+"/ipfs/"+root.String(), root, "", target, nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"a.txt": "hello world", "sub/b.txt": "bbbbbbbbbb"} {
+		got, err := os.ReadFile(filepath.Join(target, filepath.FromSlash(name)))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	target = filepath.Join(dir, "b.txt")
+	if err := downloadCar(context.Background(), server.Client(), server.URL+"/ipfs/"+root.String()+"/sub/b.txt", root, "sub/b.txt", target, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(target); err != nil || string(got) != "bbbbbbbbbb" {
+		t.Errorf("sub path = %q, %v", got, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("temporary files are left: %v", entries)
+	}
+}
+
+func TestDownloadCarRejects(t *testing.T) {
+	outside := t.TempDir()
+	escape := filepath.Join(outside, "escaped")
+
+	tests := []struct {
+		name  string
+		build func(b *dagBuilder) (dagNode, error)
+		// the root block is left out of the stream
+		dropRoot bool
+	}{
+		{name: "missing block", build: testTree, dropRoot: true},
+		{name: "parent entry", build: func(b *dagBuilder) (dagNode, error) {
+			return b.directory([]dagLink{{Name: "..", Node: testFile(b, "x")}})
+		}},
+		{name: "escaping entry", build: func(b *dagBuilder) (dagNode, error) {
+			return b.directory([]dagLink{{Name: "../escaped", Node: testFile(b, "x")}})
+		}},
+		{name: "nested entry", build: func(b *dagBuilder) (dagNode, error) {
+			return b.directory([]dagLink{{Name: "a/b", Node: testFile(b, "x")}})
+		}},
+		{name: "file through duplicate symlink", build: func(b *dagBuilder) (dagNode, error) {
+			link, err := b.symlink(escape)
+			if err != nil {
+				return dagNode{}, err
+			}
+			return b.directory([]dagLink{{Name: "a", Node: link}, {Name: "a", Node: testFile(b, "x")}})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, blocks := testDag(t, tt.build)
+			if tt.dropRoot {
+				// the root is built last
+				blocks = blocks[:len(blocks)-1]
+			}
+			server := serveCar(t, encodeCar(blocks))
+			target := filepath.Join(t.TempDir(), "tree")
+			err := downloadCar(context.Background(), server.Client(), server.URL+"/ipfs/"+root.String(), root, "", target, nil)
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if _, err = os.Lstat(target); !os.IsNotExist(err) {
+				t.Errorf("target exists after the failed download: %v", err)
+			}
+			if _, err = os.Lstat(escape); !os.IsNotExist(err) {
+				t.Errorf("file is written outside of the target: %v", err)
+			}
+		})
+	}
+}
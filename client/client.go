@@ -1,21 +1,38 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
-	"strings"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/ipfs/go-cid"
 	shell "github.com/ipfs/go-ipfs-api"
+	files "github.com/ipfs/go-ipfs-files"
 )
 
+// rpcId is the last JSON-RPC request id sent to meta server
+var rpcId int64
+
+// defaultBackupRetries is used for MetaConf.BackupRetries 0
+const defaultBackupRetries = 3
+
 type MetaClient struct {
 	key   string
 	token string
 	conf  *MetaConf
+
+	mu    sync.Mutex
+	nodes *ipfsNodes
 }
 
 func NewClient(key, token string, conf ...*MetaConf) *MetaClient {
@@ -56,136 +73,595 @@ func (c *MetaClient) WithAria2Conf(conf *Aria2Conf) *MetaClient {
 	return c
 }
 
+func (c *MetaClient) WithDownloader(downloader Downloader) *MetaClient {
+	if c.conf == nil {
+		c.conf = &MetaConf{}
+	}
+	c.conf.Downloader = downloader
+	return c
+}
+
 // Upload uploads file or directory to ipfs
-func (m *MetaClient) Upload(inputPath string) (ipfsData *IpfsData, err error) {
-	if m.conf == nil || m.conf.IpfsApi == "" || m.conf.IpfsGateway == "" {
+func (m *MetaClient) Upload(inputPath string, opts ...UploadOption) (ipfsData *IpfsData, err error) {
+	return m.UploadContext(context.Background(), inputPath, opts...)
+}
+
+// UploadContext is like Upload but binds the ipfs requests to ctx
+func (m *MetaClient) UploadContext(ctx context.Context, inputPath string, opts ...UploadOption) (ipfsData *IpfsData, err error) {
+	if len(m.conf.ipfsApis()) == 0 || m.conf.IpfsGateway == "" {
 		return nil, errors.New("ipfs api or gateway is required")
 	}
+	opt := defaultUploadOptions()
+	for _, o := range opts {
+		o.apply(&opt)
+	}
 
+	return m.uploadWithFailover(ctx, inputPath, &opt)
+}
+
+// uploadWithFailover uploads the file or directory again with the next ipfs node if the node cannot be reached
+func (m *MetaClient) uploadWithFailover(ctx context.Context, inputPath string, opt *uploadOption) (ipfsData *IpfsData, err error) {
+	err = m.withIpfsFailover(ctx, func(node *ipfsNode) error {
+		ipfsData, err = m.upload(ctx, node, inputPath, opt)
+		return err
+	})
+	return
+}
+
+// upload uploads the file or directory to the node, whose shell may be shared by concurrent uploads
+func (m *MetaClient) upload(ctx context.Context, node *ipfsNode, inputPath string, opt *uploadOption) (ipfsData *IpfsData, err error) {
+	sh := node.sh
 	info, err := os.Stat(inputPath)
 	if err != nil {
 		return
 	}
+	if opt.Resumable && info.IsDir() {
+		return nil, errors.New("resumable upload only supports files")
+	}
+	if opt.Resumable && opt.Add.OnlyHash {
+		return nil, errors.New("resumable upload does not support only hash")
+	}
+	if opt.DagSize && opt.Add.OnlyHash {
+		return nil, errors.New("dag size is not available with only hash, use DryRun instead")
+	}
+
+	// select the entries of the directory before uploading
+	var tree *uploadEntry
+	filter := newUploadFilter(opt)
+	if info.IsDir() {
+		if tree, err = filter.walk(inputPath); err != nil {
+			return
+		}
+	}
+
+	var progress *uploadProgress
+	if opt.Progress != nil {
+		if info.IsDir() {
+			progress = newTreeUploadProgress(inputPath, tree, opt.Progress)
+		} else {
+			progress = newFileUploadProgress(inputPath, info.Size(), opt.Progress)
+		}
+	}
 
-	// create an IPFS Shell client.
-	sh := shell.NewShell(m.conf.IpfsApi)
 	var ipfsCid string
-	if !info.IsDir() {
-		ipfsCid, err = uploadFileToIpfs(sh, inputPath)
+	if opt.Resumable {
+		var params unixfsParams
+		if params, err = unixfsParamsFor(opt.Add); err != nil {
+			return
+		}
+		ipfsCid, err = uploadResumable(ctx, sh, node.api, inputPath, params, progress)
+	} else if !info.IsDir() {
+		ipfsCid, err = uploadFileToIpfs(ctx, sh, inputPath, opt, progress)
 	} else {
-		ipfsCid, err = uploadDirToIpfs(sh, inputPath)
+		ipfsCid, err = uploadDirToIpfs(ctx, sh, tree, opt, progress)
+	}
+	if err != nil {
+		return
 	}
+	placement, err := placeInMfs(ctx, sh, ipfsCid, opt)
 	if err != nil {
 		return
 	}
-	return &IpfsData{
+	if opt.Resumable {
+		removeUploadJournal(inputPath)
+	}
+	ipfsData = &IpfsData{
 		IpfsCid:     ipfsCid,
 		SourceName:  inputPath,
 		DataSize:    info.Size(),
 		IsDirectory: info.IsDir(),
 		DownloadUrl: PathJoin(m.conf.IpfsGateway, "ipfs", ipfsCid),
-	}, nil
+		MfsPath:     placement.mfsPath,
+		Pinned:      opt.pinned(),
+		IpfsApi:     node.api,
+		FileCount:   1,
+		Skipped:     filter.skipped,
+		mfsCreated:  placement.mfsCreated,
+		pinAdded:    placement.pinAdded,
+	}
+	if info.IsDir() {
+		ipfsData.DataSize = tree.fileSize()
+		ipfsData.FileCount = tree.fileCount()
+	}
+	if err = dagSize(ctx, sh, ipfsData, opt); err != nil {
+		return
+	}
+	return ipfsData, m.pinRemote(ctx, sh, ipfsData, opt)
 }
 
-// Download downloads all the files related with the specified ipfsCid default,
-// and downloads specific files with the specified downloadUrl
-func (m *MetaClient) Download(ipfsCid, outPath string, downloadUrl ...string) error {
-	if m.conf == nil || m.conf.Aria2Conf == nil {
-		return errors.New("aria2 config is required")
+// UploadMany uploads the paths with a pool of WithConcurrency workers sharing the ipfs shells,
+// the result of each path is returned in the order of paths. With WithBackup the successful
+// uploads are backed up, and the returned error is the one of Backup.
+func (m *MetaClient) UploadMany(paths []string, opts ...UploadOption) ([]UploadResult, error) {
+	return m.UploadManyContext(context.Background(), paths, opts...)
+}
+
+// UploadManyContext is like UploadMany but binds the ipfs and meta server requests to ctx
+func (m *MetaClient) UploadManyContext(ctx context.Context, paths []string, opts ...UploadOption) ([]UploadResult, error) {
+	if len(m.conf.ipfsApis()) == 0 || m.conf.IpfsGateway == "" {
+		return nil, errors.New("ipfs api or gateway is required")
+	}
+	opt := defaultUploadOptions()
+	for _, o := range opts {
+		o.apply(&opt)
+	}
+	if progress := opt.Progress; progress != nil {
+		// the workers report concurrently
+		var mu sync.Mutex
+		opt.Progress = func(p UploadProgress) {
+			mu.Lock()
+			defer mu.Unlock()
+			progress(p)
+		}
 	}
 
-	// check cid from meta server
-	downInfo, err := m.DownloadFileInfo(ipfsCid)
-	if err != nil {
-		return err
+	results := make([]UploadResult, len(paths))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < opt.Concurrency && w < len(paths); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i].IpfsData, results[i].Err = m.uploadWithFailover(ctx, paths[i], &opt)
+			}
+		}()
 	}
-	if len(downInfo) == 0 {
-		return errors.New("there are no available download links")
+	for i, inputPath := range paths {
+		results[i].Path = inputPath
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		jobs <- i
 	}
+	close(jobs)
+	wg.Wait()
 
-	if len(downloadUrl) > 0 && downloadUrl[0] != "" {
-		download := downloadUrl[0]
-		if !strings.Contains(download, ipfsCid) {
-			log.Printf("datacid: %s should be included in the url %s, but it is not.", ipfsCid, download)
+	if opt.Backup == "" {
+		return results, nil
+	}
+	var ipfsDataList []*IpfsData
+	for _, result := range results {
+		if result.Err == nil {
+			ipfsDataList = append(ipfsDataList, result.IpfsData)
 		}
+	}
+	if len(ipfsDataList) == 0 {
+		return results, errors.New("no path is uploaded to backup")
+	}
+	return results, m.BackupContext(ctx, opt.Backup, ipfsDataList...)
+}
+
+// UploadAndBackup uploads the paths and backups them with the datasetName.
+// A failed backup is retried MetaConf.BackupRetries times, 3 by default, unless meta server rejects it,
+// and with MetaConf.RollbackOnFailure the uploads are removed from MFS and unpinned if they cannot be backed up.
+func (m *MetaClient) UploadAndBackup(datasetName string, paths ...string) ([]*IpfsData, error) {
+	return m.UploadAndBackupContext(context.Background(), datasetName, paths...)
+}
+
+// UploadAndBackupContext is like UploadAndBackup but binds the ipfs and meta server requests to ctx
+func (m *MetaClient) UploadAndBackupContext(ctx context.Context, datasetName string, paths ...string) ([]*IpfsData, error) {
+	if len(m.conf.ipfsApis()) == 0 || m.conf.IpfsGateway == "" {
+		return nil, errors.New("ipfs api or gateway is required")
+	}
+	if len(paths) == 0 {
+		return nil, errors.New("paths are required")
+	}
 
-		downloadFile := PathJoin(outPath, filepath.Base(downInfo[0].SourceName))
-		if downInfo[0].IsDirectory {
-			downloadFile = downloadFile + ".tar"
+	opt := defaultUploadOptions()
+	ipfsDataList := make([]*IpfsData, 0, len(paths))
+	for _, inputPath := range paths {
+		ipfsData, err := m.uploadWithFailover(ctx, inputPath, &opt)
+		if err != nil {
+			return nil, m.rollback(ipfsDataList, fmt.Errorf("upload %s: %w", inputPath, err))
 		}
+		ipfsDataList = append(ipfsDataList, ipfsData)
+	}
 
-		if err := downloadFileByAria2(m.conf.Aria2Conf, download, downloadFile); err != nil {
-			return err
+	if err := m.backupWithRetries(ctx, datasetName, ipfsDataList); err != nil {
+		return nil, m.rollback(ipfsDataList, err)
+	}
+	return ipfsDataList, nil
+}
+
+// backupWithRetries backups the uploads and retries a failed backup MetaConf.BackupRetries times,
+// defaultBackupRetries if it is 0 and none if it is negative
+func (m *MetaClient) backupWithRetries(ctx context.Context, datasetName string, ipfsDataList []*IpfsData) error {
+	retries := m.conf.BackupRetries
+	if retries == 0 {
+		retries = defaultBackupRetries
+	}
+	interval := m.conf.BackupRetryInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	err := m.BackupContext(ctx, datasetName, ipfsDataList...)
+	for retry := 0; err != nil && retry < retries && retryableBackupError(err); retry++ {
+		log.Printf("backup %s failed, retry %d/%d: %v", datasetName, retry+1, retries, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
 		}
+		err = m.BackupContext(ctx, datasetName, ipfsDataList...)
+	}
+	return err
+}
 
-	} else {
-		// aria2 download file
-		for _, info := range downInfo {
-			realUrl := info.DownloadUrl
-			if !strings.Contains(realUrl, ipfsCid) {
-				log.Printf("datacid: %s should be included in the url %s, but it is not.", ipfsCid, realUrl)
-				continue
-			}
+// retryableBackupError reports whether a failed backup may succeed if it is sent again: the failures of
+// meta server itself and the requests which do not get an answer. A rejection of an unknown kind is not retried.
+func retryableBackupError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var metaErr *MetaError
+	if errors.As(err, &metaErr) {
+		return errors.Is(err, ErrServer) ||
+			metaErr.HttpStatus == http.StatusTooManyRequests ||
+			metaErr.HttpStatus == http.StatusRequestTimeout
+	}
+	return true
+}
 
-			downloadFile := PathJoin(outPath, filepath.Base(info.SourceName))
-			if info.IsDirectory {
-				realUrl = realUrl + "?format=tar"
-				downloadFile = downloadFile + ".tar"
+// rollback removes the uploads from MFS and unpins them if MetaConf.RollbackOnFailure is set, and returns err.
+// Only the MFS entries and pins added by the uploads are removed, the ones of earlier uploads of the same data are kept.
+// It does not use the context of the upload, which may be the cause of the failure.
+func (m *MetaClient) rollback(ipfsDataList []*IpfsData, err error) error {
+	if !m.conf.RollbackOnFailure {
+		return err
+	}
+	for _, ipfsData := range ipfsDataList {
+		sh := shell.NewShell(ipfsData.IpfsApi)
+		if ipfsData.mfsCreated {
+			if rmErr := sh.FilesRm(context.Background(), ipfsData.MfsPath, true); rmErr != nil {
+				log.Printf("failed to remove %s from MFS: %v", ipfsData.MfsPath, rmErr)
 			}
-
-			err := downloadFileByAria2(m.conf.Aria2Conf, realUrl, downloadFile)
-			if err == nil {
-				return nil
+		}
+		if ipfsData.pinAdded {
+			if rmErr := unpinRecursive(context.Background(), sh, ipfsData.IpfsCid); rmErr != nil {
+				log.Printf("failed to unpin %s: %v", ipfsData.IpfsCid, rmErr)
 			}
 		}
 	}
+	return err
+}
 
+// dagSize fetches the cumulative size of the uploaded DAG if WithDagSize is given
+func dagSize(ctx context.Context, sh *shell.Shell, ipfsData *IpfsData, opt *uploadOption) error {
+	if !opt.DagSize {
+		return nil
+	}
+	stat, err := sh.FilesStat(ctx, PathJoin("/ipfs/", ipfsData.IpfsCid))
+	if err != nil {
+		return err
+	}
+	ipfsData.DagSize = int64(stat.CumulativeSize)
 	return nil
 }
 
-// Backup backups the uploaded files with the datasetName,
-// support multiple IpfsData
-func (m *MetaClient) Backup(datasetName string, ipfsDataList ...*IpfsData) error {
-	if len(ipfsDataList) == 0 {
-		return errors.New("ipfsData is required")
+// UploadReader uploads the content of r to ipfs as a file, size is used for the progress
+// and checked against the bytes read, or it is negative if unknown. It does not fail over
+// to another ipfs node, as r cannot be read again.
+func (m *MetaClient) UploadReader(name string, r io.Reader, size int64, opts ...UploadOption) (*IpfsData, error) {
+	return m.UploadReaderContext(context.Background(), name, r, size, opts...)
+}
+
+// UploadReaderContext is like UploadReader but binds the ipfs requests to ctx
+func (m *MetaClient) UploadReaderContext(ctx context.Context, name string, r io.Reader, size int64, opts ...UploadOption) (*IpfsData, error) {
+	if len(m.conf.ipfsApis()) == 0 || m.conf.IpfsGateway == "" {
+		return nil, errors.New("ipfs api or gateway is required")
+	}
+	opt := defaultUploadOptions()
+	for _, o := range opts {
+		o.apply(&opt)
+	}
+	if opt.Resumable {
+		return nil, errors.New("resumable upload only supports files")
 	}
 
-	response, err := m.httpPost(JsonRpcParams{
-		JsonRpc: "2.0",
-		Method:  "meta.StoreSourceFile",
-		Params:  []interface{}{datasetName, ipfsDataList},
-		Id:      1,
-	})
+	var progress *uploadProgress
+	if opt.Progress != nil {
+		progress = newFileUploadProgress(name, size, opt.Progress)
+	}
+
+	nodes, err := m.ipfsNodesToTry(ctx)
+	if err != nil {
+		return nil, err
+	}
+	node := nodes[0]
+	sh := node.sh
+	// pinned by placeInMfs once the size is checked, so a short or long read leaves no pin behind
+	var read int64
+	ipfsCid, err := uploadNodeToIpfs(ctx, sh, "", files.NewReaderFile(&countingReader{r: r, n: &read}), &opt, progress)
+	if err != nil {
+		return nil, err
+	}
+	if size >= 0 && read != size {
+		return nil, fmt.Errorf("read %d bytes from %s, but the size is %d", read, name, size)
+	}
+	placement, err := placeInMfs(ctx, sh, ipfsCid, &opt)
 	if err != nil {
+		return nil, err
+	}
+	ipfsData := &IpfsData{
+		IpfsCid:     ipfsCid,
+		SourceName:  name,
+		DataSize:    read,
+		IsDirectory: false,
+		DownloadUrl: PathJoin(m.conf.IpfsGateway, "ipfs", ipfsCid),
+		MfsPath:     placement.mfsPath,
+		Pinned:      opt.pinned(),
+		IpfsApi:     node.api,
+		FileCount:   1,
+		mfsCreated:  placement.mfsCreated,
+		pinAdded:    placement.pinAdded,
+	}
+	if err = dagSize(ctx, sh, ipfsData, &opt); err != nil {
+		return nil, err
+	}
+	return ipfsData, m.pinRemote(ctx, sh, ipfsData, &opt)
+}
+
+// UploadFS uploads fsys to ipfs as the directory name, e.g. an embed.FS or a fs.Sub of it.
+// The entries are selected like the ones of a directory Upload, symlinks are skipped as they cannot be read from fs.FS.
+func (m *MetaClient) UploadFS(name string, fsys fs.FS, opts ...UploadOption) (*IpfsData, error) {
+	return m.UploadFSContext(context.Background(), name, fsys, opts...)
+}
+
+// UploadFSContext is like UploadFS but binds the ipfs requests to ctx
+func (m *MetaClient) UploadFSContext(ctx context.Context, name string, fsys fs.FS, opts ...UploadOption) (ipfsData *IpfsData, err error) {
+	if len(m.conf.ipfsApis()) == 0 || m.conf.IpfsGateway == "" {
+		return nil, errors.New("ipfs api or gateway is required")
+	}
+	opt := defaultUploadOptions()
+	for _, o := range opts {
+		o.apply(&opt)
+	}
+	if opt.Resumable {
+		return nil, errors.New("resumable upload only supports files")
+	}
+
+	err = m.withIpfsFailover(ctx, func(node *ipfsNode) error {
+		ipfsData, err = m.uploadFS(ctx, node, name, fsys, &opt)
 		return err
+	})
+	return
+}
+
+// uploadFS uploads fsys to the node as the directory name
+func (m *MetaClient) uploadFS(ctx context.Context, node *ipfsNode, name string, fsys fs.FS, opt *uploadOption) (*IpfsData, error) {
+	filter := newUploadFilter(opt)
+	tree, err := filter.walkFS(fsys, ".", name)
+	if err != nil {
+		return nil, err
+	}
+	var progress *uploadProgress
+	if opt.Progress != nil {
+		progress = newTreeUploadProgress(name, tree, opt.Progress)
 	}
 
-	var res StoreSourceFileResponse
-	if err = json.Unmarshal(response, &res); err != nil {
+	sh := node.sh
+	ipfsCid, err := uploadDirToIpfs(ctx, sh, tree, opt, progress)
+	if err != nil {
+		return nil, err
+	}
+	placement, err := placeInMfs(ctx, sh, ipfsCid, opt)
+	if err != nil {
+		return nil, err
+	}
+	ipfsData := &IpfsData{
+		IpfsCid:     ipfsCid,
+		SourceName:  name,
+		DataSize:    tree.fileSize(),
+		IsDirectory: true,
+		DownloadUrl: PathJoin(m.conf.IpfsGateway, "ipfs", ipfsCid),
+		MfsPath:     placement.mfsPath,
+		Pinned:      opt.pinned(),
+		IpfsApi:     node.api,
+		FileCount:   tree.fileCount(),
+		Skipped:     filter.skipped,
+		mfsCreated:  placement.mfsCreated,
+		pinAdded:    placement.pinAdded,
+	}
+	if err = dagSize(ctx, sh, ipfsData, opt); err != nil {
+		return nil, err
+	}
+	return ipfsData, m.pinRemote(ctx, sh, ipfsData, opt)
+}
+
+// DryRun computes the IpfsData which Upload would return without an ipfs node, nothing is uploaded.
+// The DAG is built locally with the UploadOptions given, which supports the size-N chunker and the balanced layout only.
+func (m *MetaClient) DryRun(inputPath string, opts ...UploadOption) (*IpfsData, error) {
+	opt := defaultUploadOptions()
+	for _, o := range opts {
+		o.apply(&opt)
+	}
+	params, err := unixfsParamsFor(opt.Add)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	var root dagNode
+	size, count := info.Size(), 1
+	filter := newUploadFilter(&opt)
+	if info.IsDir() {
+		var tree *uploadEntry
+		if tree, err = filter.walk(inputPath); err != nil {
+			return nil, err
+		}
+		size, count = tree.fileSize(), tree.fileCount()
+		root, err = newDagBuilder(params).addTree(tree)
+	} else {
+		root, err = newDagBuilder(params).addPath(inputPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ipfsCid := root.Cid.String()
+	ipfsData := &IpfsData{
+		IpfsCid:     ipfsCid,
+		SourceName:  inputPath,
+		DataSize:    size,
+		IsDirectory: info.IsDir(),
+		FileCount:   count,
+		DagSize:     int64(root.Tsize),
+		Skipped:     filter.skipped,
+	}
+	if m.conf != nil && m.conf.IpfsGateway != "" {
+		ipfsData.DownloadUrl = PathJoin(m.conf.IpfsGateway, "ipfs", ipfsCid)
+	}
+	return ipfsData, nil
+}
+
+// Download downloads all the files related with the specified ipfsCid default,
+// and downloads specific files with the specified downloadUrl.
+// It is DownloadWithOptions with WithDownloadUrl, use DownloadWithOptions for the other options.
+func (m *MetaClient) Download(ipfsCid, outPath string, downloadUrl ...string) error {
+	var opts []DownloadOption
+	if len(downloadUrl) > 0 && downloadUrl[0] != "" {
+		opts = append(opts, WithDownloadUrl(downloadUrl[0]))
+	}
+	return m.DownloadContext(context.Background(), ipfsCid, outPath, opts...)
+}
+
+// DownloadWithOptions downloads all the files related with the specified ipfsCid default,
+// and downloads specific files with WithDownloadUrl. The sources are tried in order until one succeeds:
+// WithDownloadUrl, the links from meta server, MetaConf.IpfsGateway and MetaConf.PublicGateways,
+// and *DownloadError with the error of every source is returned if all of them fail.
+// Files are downloaded by the configured Downloader, or aria2 if Aria2Conf is set, or plain http otherwise.
+// The aria2 downloader returns once aria2 accepts the download, unless WithWait or WithProgress is given.
+// With WithCar the content is retrieved as a verified CAR instead, and WithExtract extracts a directory.
+// WithSubPath downloads only a file or directory under the ipfsCid.
+func (m *MetaClient) DownloadWithOptions(ipfsCid, outPath string, opts ...DownloadOption) error {
+	return m.DownloadContext(context.Background(), ipfsCid, outPath, opts...)
+}
+
+// DownloadContext is like DownloadWithOptions but binds the meta server and download requests to ctx
+func (m *MetaClient) DownloadContext(ctx context.Context, ipfsCid, outPath string, opts ...DownloadOption) error {
+	opt := defaultDownloadOptions()
+	for _, o := range opts {
+		o.apply(&opt)
+	}
+	downloader := m.downloader()
+
+	var root cid.Cid
+	if opt.Car {
+		var err error
+		if root, err = cid.Decode(ipfsCid); err != nil {
+			return err
+		}
+	}
+
+	// check cid from meta server
+	downInfo, err := m.DownloadFileInfoContext(ctx, ipfsCid)
+	if err != nil {
 		return err
 	}
+	if len(downInfo) == 0 {
+		return fmt.Errorf("%w: there are no available download links", ErrNotFound)
+	}
+
+	// try every source until one succeeds
+	sources, failed := m.downloadSources(ipfsCid, downInfo, &opt)
+	verifyCid := ipfsCid
+	if opt.SubPath != "" {
+		entry, err := m.resolveSubPath(ctx, ipfsCid, opt.SubPath)
+		if err != nil {
+			return err
+		}
+		if entry != nil {
+			if opt.Verify && !opt.Car && entry.cid == "" {
+				return errors.New("verifying a sub path needs the ipfs api or the gateways to resolve its cid, or use WithCar")
+			}
+			sources, failed = withSubPath(sources, failed, entry)
+			verifyCid = entry.cid
+		}
+	}
+	var result DownloadResult
+	defer func() {
+		if opt.Result != nil {
+			*opt.Result = result
+		}
+	}()
+	for _, source := range sources {
+		err := downloadFromSource(ctx, downloader, verifyCid, root, source, outPath, &opt, &result)
+		if err == nil {
+			result.Url, result.Errors = source.url, failed
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("download %s from %s failed: %v", ipfsCid, source.url, err)
+		failed = append(failed, &SourceError{Url: source.url, Err: err})
+	}
+	result.Errors = failed
+	return &DownloadError{IpfsCid: ipfsCid, Errors: failed}
+}
 
-	if res.Result.Code != "success" {
-		return errors.New("failed message from meta server")
+// downloader returns the Downloader for Download, the download options are passed to it on every call
+func (m *MetaClient) downloader() Downloader {
+	switch {
+	case m.conf != nil && m.conf.Downloader != nil:
+		return m.conf.Downloader
+	case m.conf != nil && m.conf.Aria2Conf != nil:
+		return NewAria2Downloader(m.conf.Aria2Conf)
+	default:
+		return NewHttpDownloader()
 	}
+}
 
-	return nil
+// Backup backups the uploaded files with the datasetName,
+// support multiple IpfsData
+func (m *MetaClient) Backup(datasetName string, ipfsDataList ...*IpfsData) error {
+	return m.BackupContext(context.Background(), datasetName, ipfsDataList...)
+}
+
+// BackupContext is like Backup but binds the meta server request to ctx
+func (m *MetaClient) BackupContext(ctx context.Context, datasetName string, ipfsDataList ...*IpfsData) error {
+	if len(ipfsDataList) == 0 {
+		return errors.New("ipfsData is required")
+	}
+
+	var res StoreSourceFileResponse
+	return m.call(ctx, "meta.StoreSourceFile", []interface{}{datasetName, ipfsDataList}, &res)
 }
 
 // List lists the backup files with the given datasetName
 func (m *MetaClient) List(datasetName string, pageNum, size int) (*DatasetListPager, error) {
-	response, err := m.httpPost(JsonRpcParams{
-		JsonRpc: "2.0",
-		Method:  "meta.GetDatasetList",
-		Params:  []interface{}{DatasetListReq{datasetName, pageNum, size}},
-		Id:      1,
-	})
-	if err != nil {
-		return nil, err
-	}
+	return m.ListContext(context.Background(), datasetName, pageNum, size)
+}
 
+// ListContext is like List but binds the meta server request to ctx
+func (m *MetaClient) ListContext(ctx context.Context, datasetName string, pageNum, size int) (*DatasetListPager, error) {
 	var res DatasetListResponse
-	if err = json.Unmarshal(response, &res); err != nil {
+	if err := m.call(ctx, "meta.GetDatasetList", []interface{}{DatasetListReq{datasetName, pageNum, size}}, &res); err != nil {
 		return nil, err
 	}
 
@@ -194,68 +670,105 @@ func (m *MetaClient) List(datasetName string, pageNum, size int) (*DatasetListPa
 
 // ListStatus lists the status of backup files
 func (m *MetaClient) ListStatus(datasetName, ipfsCid string, pageNum, size int) (*SourceFileStatusPager, error) {
-	response, err := m.httpPost(JsonRpcParams{
-		JsonRpc: "2.0",
-		Method:  "meta.GetSourceFileStatus",
-		Params:  []interface{}{SourceFileStatusReq{datasetName, ipfsCid, pageNum, size}},
-		Id:      1,
-	})
-	if err != nil {
-		return nil, err
-	}
+	return m.ListStatusContext(context.Background(), datasetName, ipfsCid, pageNum, size)
+}
 
+// ListStatusContext is like ListStatus but binds the meta server request to ctx
+func (m *MetaClient) ListStatusContext(ctx context.Context, datasetName, ipfsCid string, pageNum, size int) (*SourceFileStatusPager, error) {
 	var res SourceFileStatusResponse
-	if err = json.Unmarshal(response, &res); err != nil {
+	if err := m.call(ctx, "meta.GetSourceFileStatus", []interface{}{SourceFileStatusReq{datasetName, ipfsCid, pageNum, size}}, &res); err != nil {
 		return nil, err
 	}
 
 	return &res.Result.Data, nil
 }
 
+// SourceFileInfo gets the source file information of the ipfsCid from meta server
 func (m *MetaClient) SourceFileInfo(ipfsCid string) ([]*IpfsDataDetail, error) {
-	response, err := m.httpPost(JsonRpcParams{
-		JsonRpc: "2.0",
-		Method:  "meta.GetSourceFileInfo",
-		Params:  []interface{}{ipfsCid},
-		Id:      1,
-	})
-	if err != nil {
-		return nil, err
-	}
+	return m.SourceFileInfoContext(context.Background(), ipfsCid)
+}
+
+// SourceFileInfoContext is like SourceFileInfo but binds the meta server request to ctx
+func (m *MetaClient) SourceFileInfoContext(ctx context.Context, ipfsCid string) ([]*IpfsDataDetail, error) {
 	var res SourceFileInfoResponse
-	if err = json.Unmarshal(response, &res); err != nil {
+	if err := m.call(ctx, "meta.GetSourceFileInfo", []interface{}{ipfsCid}, &res); err != nil {
 		return nil, err
 	}
 
 	return res.Result.Data, nil
 }
 
+// DownloadFileInfo gets the download links of the ipfsCid from meta server
 func (m *MetaClient) DownloadFileInfo(ipfsCid string) ([]*DownloadFileInfo, error) {
-	response, err := m.httpPost(JsonRpcParams{
-		JsonRpc: "2.0",
-		Method:  "meta.GetDownloadFileInfoByIpfsCid",
-		Params:  []interface{}{ipfsCid},
-		Id:      1,
-	})
-	if err != nil {
-		return nil, err
-	}
+	return m.DownloadFileInfoContext(context.Background(), ipfsCid)
+}
 
+// DownloadFileInfoContext is like DownloadFileInfo but binds the meta server request to ctx
+func (m *MetaClient) DownloadFileInfoContext(ctx context.Context, ipfsCid string) ([]*DownloadFileInfo, error) {
 	var res DownloadFileInfoResponse
-	if err = json.Unmarshal(response, &res); err != nil {
+	if err := m.call(ctx, "meta.GetDownloadFileInfoByIpfsCid", []interface{}{ipfsCid}, &res); err != nil {
 		return nil, err
 	}
 
 	return res.Result.Data, nil
 }
 
-func (m *MetaClient) httpPost(params interface{}) ([]byte, error) {
+// call posts the JSON-RPC request to meta server and decodes the response into res,
+// any failure reported by meta server is returned as *MetaError
+func (m *MetaClient) call(ctx context.Context, method string, params []interface{}, res interface{}) error {
+	req := JsonRpcParams{
+		JsonRpc: "2.0",
+		Method:  method,
+		Params:  params,
+		Id:      int(atomic.AddInt64(&rpcId, 1)),
+	}
+	response, err := m.httpPost(ctx, req)
+	if err != nil {
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) {
+			return &MetaError{
+				Method:     method,
+				Message:    statusErr.Status,
+				HttpStatus: statusErr.StatusCode,
+				RequestId:  req.Id,
+			}
+		}
+		return err
+	}
+
+	var envelope MetaResponse
+	if err = json.Unmarshal(response, &envelope); err != nil {
+		return err
+	}
+	if envelope.Error != nil {
+		return &MetaError{
+			Method:     method,
+			Code:       strconv.Itoa(envelope.Error.Code),
+			Message:    envelope.Error.Message,
+			HttpStatus: http.StatusOK,
+			RequestId:  req.Id,
+		}
+	}
+	if envelope.Result.Code != MetaCodeSuccess {
+		return &MetaError{
+			Method:     method,
+			Code:       envelope.Result.Code,
+			Message:    envelope.Result.Message,
+			HttpStatus: http.StatusOK,
+			RequestId:  req.Id,
+		}
+	}
+
+	return json.Unmarshal(response, res)
+}
+
+func (m *MetaClient) httpPost(ctx context.Context, params interface{}) ([]byte, error) {
 	if m.key == "" || m.token == "" {
 		return nil, errors.New("key or token is required")
 	}
 	if m.conf == nil {
 		return nil, errors.New("meta server is required")
 	}
-	return httpRequestWithKey(http.MethodPost, m.conf.MetaServer, m.key, m.token, params)
+	return httpRequestWithKey(ctx, http.MethodPost, m.conf.MetaServer, m.key, m.token, params)
 
 }
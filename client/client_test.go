@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackupWithRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		retries   int
+		status    int    // http status of the failed backups
+		code      string // result code of the failed backups with http status 200
+		message   string
+		wantCalls int32
+	}{
+		{name: "default", retries: 0, status: http.StatusInternalServerError, wantCalls: 4},
+		{name: "configured", retries: 1, status: http.StatusBadGateway, wantCalls: 2},
+		{name: "disabled", retries: -1, status: http.StatusInternalServerError, wantCalls: 1},
+		{name: "too many requests", retries: 2, status: http.StatusTooManyRequests, wantCalls: 3},
+		{name: "unauthorized", status: http.StatusUnauthorized, wantCalls: 1},
+		{name: "unknown rejection", status: http.StatusOK, code: "fail", message: "quota reached", wantCalls: 1},
+		{name: "dataset exists", status: http.StatusOK, code: "fail", message: "dataset already exists", wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			meta := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				if tt.status != http.StatusOK {
+					http.Error(w, http.StatusText(tt.status), tt.status)
+					return
+				}
+				var res MetaResponse
+				res.JsonRpc, res.Id = "2.0", 1
+				res.Result.Code, res.Result.Message = tt.code, tt.message
+				json.NewEncoder(w).Encode(&res)
+			}))
+			defer meta.Close()
+
+			metaClient := NewClient("key", "token", &MetaConf{
+				MetaServer:          meta.URL,
+				BackupRetries:       tt.retries,
+				BackupRetryInterval: time.Millisecond,
+			})
+			err := metaClient.backupWithRetries(context.Background(), "dataset", []*IpfsData{{IpfsCid: "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH"}})
+			var metaErr *MetaError
+			if !errors.As(err, &metaErr) {
+				t.Errorf("err = %v, want a meta server error", err)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("backup is sent %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryableBackupError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&MetaError{HttpStatus: http.StatusServiceUnavailable}, true},
+		{&MetaError{HttpStatus: http.StatusOK, Code: "-32603"}, true},
+		{&MetaError{HttpStatus: http.StatusRequestTimeout}, true},
+		{&MetaError{HttpStatus: http.StatusOK, Code: "fail", Message: "something went wrong"}, false},
+		{&MetaError{HttpStatus: http.StatusBadRequest}, false},
+		{errors.New("connection reset by peer"), true},
+		{context.DeadlineExceeded, false},
+	}
+	for _, tt := range tests {
+		if got := retryableBackupError(tt.err); got != tt.want {
+			t.Errorf("retryableBackupError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// DefaultPublicGateways are well known public ipfs gateways, which can be set to MetaConf.PublicGateways
+var DefaultPublicGateways = []string{
+	"https://ipfs.io",
+	"https://dweb.link",
+}
+
+// DownloadResult is filled by Download with WithDownloadResult
+type DownloadResult struct {
+	Url    string         // the source the content is downloaded from, empty if all the sources failed
+	Path   string         // local path of the downloaded file, tar or directory
+	Files  []string       // files and symlinks extracted with WithExtract, slash separated paths relative to Path
+	Errors []*SourceError // the sources which failed, in the order they are tried
+}
+
+// downloadSource is a url Download tries, with the name and type of the content from meta server
+type downloadSource struct {
+	url     string
+	name    string
+	isDir   bool
+	subPath string // of WithSubPath, included in url
+}
+
+// subPathResolveTimeout limits the resolution of WithSubPath with the ipfs api, a node which has to find
+// the blocks of the path in the network can take long, and the gateways are tried after it
+const subPathResolveTimeout = 30 * time.Second
+
+// subPathEntry is the entry of WithSubPath under the ipfs cid
+type subPathEntry struct {
+	path  string // slash separated, without the leading and trailing slashes
+	isDir bool
+	cid   string // empty if it is not resolved
+}
+
+// resolveSubPath looks up the sub path with the ipfs api if it is configured, and with the verified blocks
+// of MetaConf.IpfsGateway and MetaConf.PublicGateways if the ipfs api fails or is not configured. If no ipfs
+// api is configured and the gateways cannot resolve it, it is a directory if it ends with a slash.
+// It returns nil for the root.
+func (m *MetaClient) resolveSubPath(ctx context.Context, ipfsCid, subPath string) (*subPathEntry, error) {
+	clean := strings.Trim(path.Clean("/"+subPath), "/")
+	if clean == "" {
+		return nil, nil
+	}
+	entry := &subPathEntry{path: clean, isDir: strings.HasSuffix(subPath, "/")}
+	var err error
+	if len(m.conf.ipfsApis()) > 0 {
+		resolveCtx, cancel := context.WithTimeout(ctx, subPathResolveTimeout)
+		err = m.withIpfsFailover(resolveCtx, func(node *ipfsNode) error {
+			stat, err := node.sh.FilesStat(resolveCtx, PathJoin("/ipfs/", ipfsCid, clean))
+			if err != nil {
+				return err
+			}
+			entry.cid, entry.isDir = stat.Hash, stat.Type == EntryTypeDirectory
+			return nil
+		})
+		cancel()
+		if err == nil {
+			return entry, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Printf("resolve %s in %s with the ipfs api failed, try the gateways: %v", clean, ipfsCid, err)
+	}
+
+	var gateways []string
+	if m.conf != nil {
+		gateways = append(append(gateways, m.conf.IpfsGateway), m.conf.PublicGateways...)
+	}
+	for _, gateway := range gateways {
+		if gateway == "" {
+			continue
+		}
+		c, isDir, gatewayErr := resolveFromGateway(ctx, http.DefaultClient, gateway, ipfsCid, clean)
+		if gatewayErr == nil {
+			entry.cid, entry.isDir = c, isDir
+			return entry, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Printf("resolve %s in %s with %s failed: %v", clean, ipfsCid, gateway, gatewayErr)
+		if err == nil {
+			err = gatewayErr
+		}
+	}
+	if len(m.conf.ipfsApis()) == 0 {
+		return entry, nil
+	}
+	return nil, fmt.Errorf("resolve %s in %s: %w", clean, ipfsCid, err)
+}
+
+// resolveFromGateway resolves the path under ipfsCid with the blocks fetched with ?format=raw, each one
+// on the way is verified against its cid, and tells a directory by the unixfs type of the last one
+func resolveFromGateway(ctx context.Context, client *http.Client, gateway, ipfsCid, p string) (string, bool, error) {
+	root, err := cid.Decode(ipfsCid)
+	if err != nil {
+		return "", false, err
+	}
+	c, err := resolveUnixfsPath(func(c cid.Cid) ([]byte, error) {
+		return fetchBlock(ctx, client, gateway, c)
+	}, root, p)
+	if err != nil {
+		return "", false, err
+	}
+	entry := DirEntry{Type: EntryTypeFile}
+	if c.Type() == cid.DagProtobuf {
+		if err = dagPbEntryInfo(ctx, client, gateway, &entry, c); err != nil {
+			return "", false, err
+		}
+	}
+	return c.String(), entry.Type == EntryTypeDirectory, nil
+}
+
+// withSubPath points the sources to the sub path entry, the sources whose url cannot be parsed are returned as failed
+func withSubPath(sources []downloadSource, failed []*SourceError, entry *subPathEntry) ([]downloadSource, []*SourceError) {
+	var subSources []downloadSource
+	for _, source := range sources {
+		u, err := url.Parse(source.url)
+		if err != nil {
+			failed = append(failed, &SourceError{Url: source.url, Err: err})
+			continue
+		}
+		u.Path, u.RawPath = path.Join(u.Path, entry.path), ""
+		// the file name of the root does not apply to the sub path
+		query := u.Query()
+		query.Del("filename")
+		u.RawQuery = query.Encode()
+		subSources = append(subSources, downloadSource{
+			url:     u.String(),
+			name:    path.Base(entry.path),
+			isDir:   entry.isDir,
+			subPath: entry.path,
+		})
+	}
+	return subSources, failed
+}
+
+// downloadSources returns the sources in priority order: WithDownloadUrl, the meta server links,
+// MetaConf.IpfsGateway and MetaConf.PublicGateways. The meta server links without the cid are returned as failed.
+func (m *MetaClient) downloadSources(ipfsCid string, downInfo []*DownloadFileInfo, opt *downloadOption) ([]downloadSource, []*SourceError) {
+	var sources []downloadSource
+	var failed []*SourceError
+	seen := map[string]bool{}
+	add := func(u string, info *DownloadFileInfo) {
+		if u == "" || seen[u] {
+			return
+		}
+		seen[u] = true
+		sources = append(sources, downloadSource{url: u, name: info.SourceName, isDir: info.IsDirectory})
+	}
+
+	if opt.DownloadUrl != "" {
+		if !strings.Contains(opt.DownloadUrl, ipfsCid) {
+			log.Printf("datacid: %s should be included in the url %s, but it is not.", ipfsCid, opt.DownloadUrl)
+		}
+		add(opt.DownloadUrl, downInfo[0])
+	}
+	for _, info := range downInfo {
+		if !strings.Contains(info.DownloadUrl, ipfsCid) {
+			failed = append(failed, &SourceError{
+				Url: info.DownloadUrl,
+				Err: fmt.Errorf("datacid: %s should be included in the url, but it is not", ipfsCid),
+			})
+			continue
+		}
+		add(info.DownloadUrl, info)
+	}
+	var gateways []string
+	if m.conf != nil {
+		gateways = append(append(gateways, m.conf.IpfsGateway), m.conf.PublicGateways...)
+	}
+	for _, gateway := range gateways {
+		if gateway != "" {
+			add(PathJoin(gateway, "ipfs", ipfsCid), downInfo[0])
+		}
+	}
+	return sources, failed
+}
+
+// downloadFromSource downloads the source into outPath and records the local path in result,
+// a directory is downloaded as a tar unless WithCar or WithExtract is given. ipfsCid is the cid of the source content for WithVerify.
+func downloadFromSource(ctx context.Context, downloader Downloader, ipfsCid string, root cid.Cid, source downloadSource, outPath string, opt *downloadOption, result *DownloadResult) error {
+	downloadFile := PathJoin(outPath, filepath.Base(source.name))
+	if opt.Car {
+		if err := downloadCar(ctx, http.DefaultClient, source.url, root, source.subPath, downloadFile, opt.Progress); err != nil {
+			return err
+		}
+		result.Path = downloadFile
+		return nil
+	}
+	if source.isDir && opt.Extract {
+		extracted, err := downloadTar(ctx, http.DefaultClient, source.url, ipfsCid, downloadFile, opt.Verify, opt.VerifyParams, opt.Progress)
+		if err != nil {
+			return err
+		}
+		result.Path, result.Files = downloadFile, extracted
+		return nil
+	}
+
+	downUrl := source.url
+	if source.isDir {
+		var err error
+		if downUrl, err = tarRequestUrl(downUrl); err != nil {
+			return err
+		}
+		downloadFile = downloadFile + ".tar"
+	}
+	// the wait is passed on every call, a configured downloader does not know the options of the Download call
+	downloaderOpts := DownloaderOptions{Wait: opt.Wait, PollInterval: opt.PollInterval, Progress: opt.Progress}
+	if err := downloader.Download(ctx, downUrl, downloadFile, downloaderOpts); err != nil {
+		return err
+	}
+	if opt.Verify {
+		if err := verifyDownload(ipfsCid, downloadFile, source.isDir, opt.VerifyParams); err != nil {
+			return err
+		}
+	}
+	result.Path = downloadFile
+	return nil
+}
+
+// tarRequestUrl asks the gateway for the directory as a tar, unless the url selects a format already
+func tarRequestUrl(gatewayUrl string) (string, error) {
+	u, err := url.Parse(gatewayUrl)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	if query.Get("format") != "" {
+		return gatewayUrl, nil
+	}
+	query.Set("format", "tar")
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,226 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// fakeAria2 is an aria2 JSON-RPC server which completes a download on the second status poll
+type fakeAria2 struct {
+	*httptest.Server
+	mu      sync.Mutex
+	added   int
+	polls   int
+	uri     string
+	outPath string
+}
+
+func newFakeAria2(t *testing.T) *fakeAria2 {
+	a := &fakeAria2{}
+	a.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		var result interface{}
+		switch payload.Method {
+		case aria2AddURI:
+			var uris []string
+			var option Aria2DownloadOption
+			json.Unmarshal(payload.Params[1], &uris)
+			json.Unmarshal(payload.Params[2], &option)
+			a.added++
+			a.uri, a.outPath = uris[0], filepath.Join(option.Dir, option.Out)
+			result = "gid"
+		case aria2Status:
+			a.polls++
+			status := Aria2StatusResult{Gid: "gid", Status: Aria2StatusActive}
+			if a.polls >= 2 {
+				if err := fetchTo(a.uri, a.outPath); err != nil {
+					status.Status, status.ErrorMessage = Aria2StatusError, err.Error()
+				} else {
+					status.Status = Aria2StatusComplete
+				}
+			}
+			result = status
+		default:
+			http.Error(w, "unexpected method "+payload.Method, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": "1", "result": result})
+	}))
+	t.Cleanup(a.Close)
+	return a
+}
+
+func (a *fakeAria2) conf(t *testing.T) *Aria2Conf {
+	u, err := url.Parse(a.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Aria2Conf{Host: u.Hostname(), Port: port}
+}
+
+func fetchTo(uri, outPath string) error {
+	response, err := http.Get(uri)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outPath, data, 0644)
+}
+
+// newFakeMetaServer answers meta.GetDownloadFileInfoByIpfsCid with the download info
+func newFakeMetaServer(t *testing.T, info ...*DownloadFileInfo) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res DownloadFileInfoResponse
+		res.JsonRpc, res.Id = "2.0", 1
+		res.Result.Code, res.Result.Data = MetaCodeSuccess, info
+		json.NewEncoder(w).Encode(&res)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDownloadWaitsForConfiguredAria2Downloader(t *testing.T) {
+	// the v0 cid of "hello world"
+	const ipfsCid = "Qmf412jQZiuVUtdgnB36FXFX7xg5V6KEbSJ4dpQuhkLyfD"
+	content := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello world"))
+	}))
+	defer content.Close()
+
+	tests := []struct {
+		name     string
+		opts     []DownloadOption
+		complete bool // the file is complete once Download returns
+	}{
+		{name: "accepted", opts: nil, complete: false},
+		{name: "wait", opts: []DownloadOption{WithWait(true)}, complete: true},
+		{name: "verify", opts: []DownloadOption{WithVerify(true)}, complete: true},
+		{name: "progress", opts: []DownloadOption{WithProgress(func(DownloadProgress) {})}, complete: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aria2 := newFakeAria2(t)
+			meta := newFakeMetaServer(t, &DownloadFileInfo{SourceName: "hello.txt", DownloadUrl: content.URL + "/ipfs/" + ipfsCid})
+			metaClient := NewClient("key", "token", &MetaConf{MetaServer: meta.URL}).
+				WithDownloader(NewAria2Downloader(aria2.conf(t)))
+
+			outPath := t.TempDir()
+			opts := append([]DownloadOption{WithPollInterval(10 * time.Millisecond)}, tt.opts...)
+			if err := metaClient.DownloadWithOptions(ipfsCid, outPath, opts...); err != nil {
+				t.Fatal(err)
+			}
+
+			aria2.mu.Lock()
+			defer aria2.mu.Unlock()
+			if aria2.added != 1 {
+				t.Errorf("aria2 is asked to download %d times, want 1", aria2.added)
+			}
+			got, err := os.ReadFile(filepath.Join(outPath, "hello.txt"))
+			if !tt.complete {
+				if !os.IsNotExist(err) {
+					t.Errorf("file exists before aria2 completes it: %v", err)
+				}
+				return
+			}
+			if err != nil || string(got) != "hello world" {
+				t.Errorf("downloaded %q, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestResolveSubPath(t *testing.T) {
+	root, blocks := testDag(t, testTree)
+	store := map[cid.Cid][]byte{}
+	for _, block := range blocks {
+		store[block.cid] = block.data
+	}
+	resolve := func(p string) string {
+		c, err := resolveUnixfsPath(func(c cid.Cid) ([]byte, error) { return store[c], nil }, root, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.String()
+	}
+	gateway := serveBlocks(t, blocks)
+	ipfsApi := newFakeIpfsApi(t, map[string]string{"/ipfs/" + root.String() + "/sub": EntryTypeDirectory})
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	tests := []struct {
+		name    string
+		conf    *MetaConf
+		subPath string
+		want    *subPathEntry // nil expects an error
+	}{
+		{
+			name: "ipfs api", conf: &MetaConf{IpfsApi: ipfsApi.URL}, subPath: "sub",
+			// the fake ipfs api answers the same hash for every path
+			want: &subPathEntry{path: "sub", isDir: true, cid: "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH"},
+		},
+		{
+			name: "ipfs api down", conf: &MetaConf{IpfsApi: down.URL, IpfsGateway: gateway.URL}, subPath: "sub",
+			want: &subPathEntry{path: "sub", isDir: true, cid: resolve("sub")},
+		},
+		{
+			name: "ipfs api fails", conf: &MetaConf{IpfsApi: ipfsApi.URL, IpfsGateway: gateway.URL}, subPath: "/sub/b.txt",
+			want: &subPathEntry{path: "sub/b.txt", cid: resolve("sub/b.txt")},
+		},
+		{
+			name: "gateway", conf: &MetaConf{PublicGateways: []string{down.URL, gateway.URL}}, subPath: "a.txt",
+			want: &subPathEntry{path: "a.txt", cid: resolve("a.txt")},
+		},
+		{
+			name: "guessed", conf: &MetaConf{IpfsGateway: down.URL}, subPath: "a.txt/",
+			want: &subPathEntry{path: "a.txt", isDir: true},
+		},
+		{name: "not found", conf: &MetaConf{IpfsApi: ipfsApi.URL, IpfsGateway: gateway.URL}, subPath: "missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := NewClient("key", "token", tt.conf).resolveSubPath(context.Background(), root.String(), tt.subPath)
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("no error, resolved %+v", entry)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *entry != *tt.want {
+				t.Errorf("resolved %+v, want %+v", entry, tt.want)
+			}
+		})
+	}
+}
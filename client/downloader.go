@@ -0,0 +1,523 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Downloader downloads the content of downUrl to the file outPath with the options of the Download call
+type Downloader interface {
+	Download(ctx context.Context, downUrl, outPath string, opts DownloaderOptions) error
+}
+
+// DownloaderOptions are the options of a Download call for the Downloader
+type DownloaderOptions struct {
+	Wait         bool                   // return only once the file is complete, downloaders which always do ignore it
+	PollInterval time.Duration          // how often the status is polled while waiting, 0 keeps the interval of the downloader
+	Progress     func(DownloadProgress) // called periodically if it is not nil, it implies Wait
+}
+
+// Aria2Downloader downloads files by the aria2 service
+type Aria2Downloader struct {
+	Conf         *Aria2Conf
+	Wait         bool          // always wait until aria2 completes the download, otherwise only if DownloaderOptions.Wait is set
+	PollInterval time.Duration // how often the aria2 download status is polled while waiting
+}
+
+func NewAria2Downloader(conf *Aria2Conf) *Aria2Downloader {
+	return &Aria2Downloader{
+		Conf:         conf,
+		PollInterval: time.Second,
+	}
+}
+
+// Download asks aria2 to download downUrl into outPath, it returns once aria2 accepts the download
+// unless the Wait field, DownloaderOptions.Wait or DownloaderOptions.Progress is set
+func (d *Aria2Downloader) Download(ctx context.Context, downUrl, outPath string, opts DownloaderOptions) error {
+	if d.Conf == nil {
+		return errors.New("aria2 config is required")
+	}
+	aria2 := NewAria2Client(d.Conf.Host, d.Conf.Secret, d.Conf.Port)
+	outDir := filepath.Dir(outPath)
+	fileName := filepath.Base(outPath)
+	aria2Download, err := aria2.DownloadFileContext(ctx, downUrl, outDir, fileName)
+	if err != nil {
+		return err
+	}
+
+	if aria2Download.Gid == "" {
+		return errors.New("no gid returned when asking aria2 to download")
+	}
+
+	if !d.Wait && !opts.Wait && opts.Progress == nil {
+		return nil
+	}
+	interval := opts.PollInterval
+	if interval <= 0 {
+		interval = d.PollInterval
+	}
+	return d.wait(ctx, aria2, aria2Download.Gid, interval, opts.Progress)
+}
+
+// wait polls the status of the aria2 download until it is complete or stopped
+func (d *Aria2Downloader) wait(ctx context.Context, aria2 *Aria2Client, gid string, interval time.Duration, progress func(DownloadProgress)) error {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		status, err := aria2.TellStatusContext(ctx, gid)
+		if err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(status.Progress())
+		}
+
+		switch status.Status {
+		case Aria2StatusComplete:
+			return nil
+		case Aria2StatusError, Aria2StatusRemoved:
+			return &Aria2DownloadError{
+				Gid:          gid,
+				Status:       status.Status,
+				ErrorCode:    status.ErrorCode,
+				ErrorMessage: status.ErrorMessage,
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// HttpDownloader downloads files by plain http requests, it needs no external service.
+// The content is written to outPath.part and renamed to outPath once it is complete.
+// If the server supports ranged requests, the file is downloaded in parallel segments
+// and an interrupted download resumes from outPath.part on the next call.
+type HttpDownloader struct {
+	Client           *http.Client  // defaults to http.DefaultClient
+	Segments         int           // maximum parallel ranged requests
+	MinSegmentSize   int64         // minimum bytes of a segment
+	ProgressInterval time.Duration // how often progress is reported
+}
+
+func NewHttpDownloader() *HttpDownloader {
+	return &HttpDownloader{
+		Client:           http.DefaultClient,
+		Segments:         4,
+		MinSegmentSize:   8 << 20,
+		ProgressInterval: time.Second,
+	}
+}
+
+const (
+	partSuffix  = ".part"
+	stateSuffix = ".state"
+)
+
+// httpDownloadState records the progress of a segmented download next to the .part file
+type httpDownloadState struct {
+	Url          string         `json:"url"`
+	TotalLength  int64          `json:"total_length"`
+	ETag         string         `json:"etag,omitempty"`
+	LastModified string         `json:"last_modified,omitempty"`
+	Segments     []*httpSegment `json:"segments"`
+}
+
+type httpSegment struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"` // inclusive
+	Done  int64 `json:"done"`
+}
+
+func (s *httpSegment) done() int64 {
+	return atomic.LoadInt64(&s.Done)
+}
+
+func (st *httpDownloadState) completed() (completed int64) {
+	for _, seg := range st.Segments {
+		completed += seg.done()
+	}
+	return
+}
+
+// Download downloads downUrl into outPath and returns once it is complete, opts.Progress is reported
+// every ProgressInterval
+func (d *HttpDownloader) Download(ctx context.Context, downUrl, outPath string, opts DownloaderOptions) error {
+	progress := opts.Progress
+	if err := os.MkdirAll(filepath.Dir(outPath), os.ModePerm); err != nil {
+		return err
+	}
+
+	partPath := outPath + partSuffix
+	statePath := partPath + stateSuffix
+	state, err := d.probe(ctx, downUrl)
+	if err != nil {
+		return err
+	}
+
+	if state == nil {
+		// no ranged requests, start from zero
+		os.Remove(statePath)
+		if err = d.downloadStream(ctx, downUrl, partPath, progress); err != nil {
+			return err
+		}
+	} else {
+		if saved := loadHttpDownloadState(statePath); saved != nil && saved.matches(state) && partFileMatches(partPath, state.TotalLength) {
+			state = saved
+		} else {
+			// the done ranges of a missing or truncated .part file would be zeros
+			os.Remove(partPath)
+			state.Segments = d.segments(state.TotalLength)
+		}
+		if err = d.downloadSegments(ctx, state, partPath, statePath, progress); err != nil {
+			return err
+		}
+		os.Remove(statePath)
+	}
+
+	return os.Rename(partPath, outPath)
+}
+
+// probe asks the server whether it supports ranged requests,
+// it returns nil state if the content can only be downloaded in one stream
+func (d *HttpDownloader) probe(ctx context.Context, downUrl string) (*httpDownloadState, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, downUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Range", "bytes=0-0")
+	response, err := d.client().Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		return nil, nil
+	default:
+		return nil, newHttpStatusError(response, downUrl)
+	}
+
+	total := contentRangeTotal(response.Header.Get("Content-Range"))
+	if total <= 0 {
+		return nil, nil
+	}
+	return &httpDownloadState{
+		Url:          downUrl,
+		TotalLength:  total,
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
+	}, nil
+}
+
+// contentRangeTotal parses the complete length of Content-Range: bytes 0-0/1234
+func contentRangeTotal(contentRange string) int64 {
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return -1
+	}
+	total, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return total
+}
+
+func (d *HttpDownloader) segments(total int64) []*httpSegment {
+	count := d.Segments
+	if count <= 0 {
+		count = 1
+	}
+	if d.MinSegmentSize > 0 {
+		if max := (total + d.MinSegmentSize - 1) / d.MinSegmentSize; int64(count) > max {
+			count = int(max)
+		}
+	}
+	if count <= 0 {
+		count = 1
+	}
+
+	size := total / int64(count)
+	segments := make([]*httpSegment, 0, count)
+	for i := 0; i < count; i++ {
+		seg := &httpSegment{Start: int64(i) * size, End: int64(i+1)*size - 1}
+		if i == count-1 {
+			seg.End = total - 1
+		}
+		segments = append(segments, seg)
+	}
+	return segments
+}
+
+func (d *HttpDownloader) downloadSegments(ctx context.Context, state *httpDownloadState, partPath, statePath string, progress func(DownloadProgress)) error {
+	file, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err = file.Truncate(state.TotalLength); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		active   int32
+	)
+	// cancel the other segments once one fails
+	for _, seg := range state.Segments {
+		if seg.Start+seg.done() > seg.End {
+			continue
+		}
+		wg.Add(1)
+		atomic.AddInt32(&active, 1)
+		go func(seg *httpSegment) {
+			defer wg.Done()
+			defer atomic.AddInt32(&active, -1)
+			if err := d.downloadSegment(ctx, state, seg, file); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(seg)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		wg.Wait()
+		errc <- firstErr
+	}()
+
+	connections := func() int { return int(atomic.LoadInt32(&active)) }
+	checkpoint := func() { state.save(statePath) }
+	if err = d.report(errc, state.TotalLength, state.completed, connections, progress, checkpoint); err != nil {
+		// keep the progress, so the next call resumes the download
+		state.save(statePath)
+		return err
+	}
+	return file.Sync()
+}
+
+func (d *HttpDownloader) downloadSegment(ctx context.Context, state *httpDownloadState, seg *httpSegment, file *os.File) error {
+	offset := seg.Start + seg.done()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, state.Url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, seg.End))
+	if state.ETag != "" {
+		request.Header.Set("If-Range", state.ETag)
+	}
+	response, err := d.client().Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusPartialContent {
+		if response.StatusCode == http.StatusOK {
+			return fmt.Errorf("content of %s changed or ranged request is not supported", state.Url)
+		}
+		return newHttpStatusError(response, state.Url)
+	}
+
+	buf := make([]byte, 32<<10)
+	for offset <= seg.End {
+		n, err := response.Body.Read(buf)
+		if n > 0 {
+			if remain := seg.End - offset + 1; int64(n) > remain {
+				n = int(remain)
+			}
+			if _, werr := file.WriteAt(buf[:n], offset); werr != nil {
+				return werr
+			}
+			offset += int64(n)
+			atomic.AddInt64(&seg.Done, int64(n))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if offset <= seg.End {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// downloadStream downloads the content in one request
+func (d *HttpDownloader) downloadStream(ctx context.Context, downUrl, partPath string, progress func(DownloadProgress)) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, downUrl, nil)
+	if err != nil {
+		return err
+	}
+	response, err := d.client().Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return newHttpStatusError(response, downUrl)
+	}
+
+	file, err := os.Create(partPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var completed int64
+	errc := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(file, &countingReader{r: response.Body, n: &completed})
+		errc <- err
+	}()
+
+	loaded := func() int64 { return atomic.LoadInt64(&completed) }
+	if err = d.report(errc, response.ContentLength, loaded, func() int { return 1 }, progress, nil); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// report calls progress periodically until the download result arrives on errc and returns it,
+// checkpoint is called on every tick if not nil
+func (d *HttpDownloader) report(errc <-chan error, total int64, completed func() int64, connections func() int, progress func(DownloadProgress), checkpoint func()) error {
+	interval := d.ProgressInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	if total < 0 {
+		total = 0
+	}
+	last, lastTime := completed(), time.Now()
+	send := func(status string, conns int) {
+		if progress == nil {
+			return
+		}
+		now, current := time.Now(), completed()
+		var speed int64
+		if elapsed := now.Sub(lastTime).Seconds(); elapsed > 0 {
+			speed = int64(float64(current-last) / elapsed)
+		}
+		last, lastTime = current, now
+		progress(DownloadProgress{
+			Status:          status,
+			CompletedLength: current,
+			TotalLength:     total,
+			DownloadSpeed:   speed,
+			Connections:     conns,
+		})
+	}
+
+	for {
+		select {
+		case err := <-errc:
+			if err != nil {
+				send(Aria2StatusError, 0)
+			} else {
+				send(Aria2StatusComplete, 0)
+			}
+			return err
+		case <-ticker.C:
+			send(Aria2StatusActive, connections())
+			if checkpoint != nil {
+				checkpoint()
+			}
+		}
+	}
+}
+
+func (d *HttpDownloader) client() *http.Client {
+	if d.Client == nil {
+		return http.DefaultClient
+	}
+	return d.Client
+}
+
+func loadHttpDownloadState(statePath string) *httpDownloadState {
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		return nil
+	}
+	var state httpDownloadState
+	if err = json.Unmarshal(data, &state); err != nil {
+		return nil
+	}
+	return &state
+}
+
+// matches reports whether the saved state belongs to the same content
+func (st *httpDownloadState) matches(probe *httpDownloadState) bool {
+	return st.Url == probe.Url &&
+		st.TotalLength == probe.TotalLength &&
+		st.ETag == probe.ETag &&
+		st.LastModified == probe.LastModified &&
+		len(st.Segments) > 0
+}
+
+// partFileMatches reports whether the .part file exists with the size of the content,
+// which it is truncated to before any segment is written
+func partFileMatches(partPath string, size int64) bool {
+	info, err := os.Stat(partPath)
+	return err == nil && info.Mode().IsRegular() && info.Size() == size
+}
+
+func (st *httpDownloadState) save(statePath string) {
+	segments := make([]*httpSegment, 0, len(st.Segments))
+	for _, seg := range st.Segments {
+		segments = append(segments, &httpSegment{Start: seg.Start, End: seg.End, Done: seg.done()})
+	}
+	data, err := json.Marshal(&httpDownloadState{
+		Url:          st.Url,
+		TotalLength:  st.TotalLength,
+		ETag:         st.ETag,
+		LastModified: st.LastModified,
+		Segments:     segments,
+	})
+	if err != nil {
+		return
+	}
+	os.WriteFile(statePath, data, 0644)
+}
+
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,255 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testContent is the content served by the test servers
+var testContent = func() []byte {
+	content := make([]byte, 1000)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	return content
+}()
+
+// rangeServer serves testContent and records the Range headers of the requests,
+// with ranges false the Range headers are ignored
+type rangeServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	ranges []string
+}
+
+func newRangeServer(t *testing.T, ranges bool) *rangeServer {
+	s := &rangeServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		s.mu.Unlock()
+		if !ranges {
+			w.Write(testContent)
+			return
+		}
+		w.Header().Set("ETag", `"content"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(testContent))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *rangeServer) requested() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.ranges...)
+}
+
+func testHttpDownloader(client *http.Client) *HttpDownloader {
+	return &HttpDownloader{Client: client, Segments: 4, MinSegmentSize: 100, ProgressInterval: 10 * time.Millisecond}
+}
+
+// checkDownloaded checks the downloaded content and that no .part or .state file is left
+func checkDownloaded(t *testing.T, outPath string) {
+	t.Helper()
+	got, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, testContent) {
+		t.Errorf("downloaded content differs, %d bytes", len(got))
+	}
+	for _, suffix := range []string{partSuffix, partSuffix + stateSuffix} {
+		if _, err = os.Stat(outPath + suffix); !os.IsNotExist(err) {
+			t.Errorf("%s is left: %v", suffix, err)
+		}
+	}
+}
+
+func TestHttpDownloader(t *testing.T) {
+	tests := []struct {
+		name         string
+		ranges       bool
+		wantRequests int
+	}{
+		{name: "segments", ranges: true, wantRequests: 5},
+		{name: "single stream", ranges: false, wantRequests: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRangeServer(t, tt.ranges)
+			outPath := filepath.Join(t.TempDir(), "sub", "file")
+			var last DownloadProgress
+			err := testHttpDownloader(server.Client()).Download(context.Background(), server.URL, outPath, DownloaderOptions{Progress: func(p DownloadProgress) {
+				last = p
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkDownloaded(t, outPath)
+			if requests := server.requested(); len(requests) != tt.wantRequests {
+				t.Errorf("requests %q, want %d", requests, tt.wantRequests)
+			}
+			if last.Status != Aria2StatusComplete || last.CompletedLength != int64(len(testContent)) {
+				t.Errorf("last progress %+v", last)
+			}
+		})
+	}
+}
+
+func TestHttpDownloaderResume(t *testing.T) {
+	half := int64(len(testContent) / 2)
+	tests := []struct {
+		name string
+		// prepare writes the .part and .state files of an interrupted download of url
+		prepare func(t *testing.T, d *HttpDownloader, url, partPath string)
+		resumed bool
+	}{
+		{
+			name: "resume",
+			prepare: func(t *testing.T, d *HttpDownloader, url, partPath string) {
+				writePart(t, partPath, testContent[:half], int64(len(testContent)))
+				saveTestState(d, url, `"content"`, partPath, half)
+			},
+			resumed: true,
+		},
+		{
+			name: "missing part",
+			prepare: func(t *testing.T, d *HttpDownloader, url, partPath string) {
+				saveTestState(d, url, `"content"`, partPath, half)
+			},
+		},
+		{
+			name: "truncated part",
+			prepare: func(t *testing.T, d *HttpDownloader, url, partPath string) {
+				writePart(t, partPath, testContent[:half], half)
+				saveTestState(d, url, `"content"`, partPath, half)
+			},
+		},
+		{
+			name: "changed content",
+			prepare: func(t *testing.T, d *HttpDownloader, url, partPath string) {
+				writePart(t, partPath, bytes.Repeat([]byte{1}, int(half)), int64(len(testContent)))
+				saveTestState(d, url, `"old"`, partPath, half)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRangeServer(t, true)
+			d := testHttpDownloader(server.Client())
+			outPath := filepath.Join(t.TempDir(), "file")
+			tt.prepare(t, d, server.URL, outPath+partSuffix)
+
+			if err := d.Download(context.Background(), server.URL, outPath, DownloaderOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			checkDownloaded(t, outPath)
+
+			// the first half is downloaded again unless the download resumes
+			var fromStart bool
+			for _, r := range server.requested() {
+				fromStart = fromStart || strings.HasPrefix(r, "bytes=0-") && r != "bytes=0-0"
+			}
+			if fromStart == tt.resumed {
+				t.Errorf("resumed = %v, want %v, requests %q", !fromStart, tt.resumed, server.requested())
+			}
+		})
+	}
+}
+
+// writePart writes data to the .part file of the size
+func writePart(t *testing.T, partPath string, data []byte, size int64) {
+	t.Helper()
+	if err := os.WriteFile(partPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(partPath, size); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// saveTestState saves the state of the segments of testContent, with the bytes before done completed
+func saveTestState(d *HttpDownloader, url, etag, partPath string, done int64) {
+	state := &httpDownloadState{Url: url, TotalLength: int64(len(testContent)), ETag: etag}
+	state.Segments = d.segments(state.TotalLength)
+	for _, seg := range state.Segments {
+		if done > seg.Start {
+			seg.Done = seg.End - seg.Start + 1
+			if done <= seg.End {
+				seg.Done = done - seg.Start
+			}
+		}
+	}
+	state.save(partPath + stateSuffix)
+}
+
+func TestHttpDownloaderStatusError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	outPath := filepath.Join(t.TempDir(), "file")
+	if err := testHttpDownloader(server.Client()).Download(context.Background(), server.URL, outPath, DownloaderOptions{}); err == nil {
+		t.Fatal("no error")
+	}
+	if _, err := os.Stat(outPath); !os.IsNotExist(err) {
+		t.Errorf("file is created: %v", err)
+	}
+}
+
+func TestHttpDownloaderSegments(t *testing.T) {
+	tests := []struct {
+		segments int
+		minSize  int64
+		total    int64
+		want     int
+	}{
+		{segments: 4, minSize: 100, total: 1000, want: 4},
+		{segments: 4, minSize: 400, total: 1000, want: 3},
+		{segments: 4, minSize: 8 << 20, total: 1, want: 1},
+		{segments: 0, minSize: 0, total: 1000, want: 1},
+	}
+	for _, tt := range tests {
+		d := &HttpDownloader{Segments: tt.segments, MinSegmentSize: tt.minSize}
+		segments := d.segments(tt.total)
+		if len(segments) != tt.want {
+			t.Errorf("%+v: %d segments, want %d", tt, len(segments), tt.want)
+			continue
+		}
+		// the segments cover the content without gaps
+		var next int64
+		for _, seg := range segments {
+			if seg.Start != next || seg.End < seg.Start {
+				t.Errorf("%+v: segment %+v after %d", tt, seg, next)
+			}
+			next = seg.End + 1
+		}
+		if next != tt.total {
+			t.Errorf("%+v: segments end at %d", tt, next)
+		}
+	}
+}
+
+func TestContentRangeTotal(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int64
+	}{
+		{"bytes 0-0/1234", 1234},
+		{"bytes 100-199/*", -1},
+		{"bytes */1234", 1234},
+		{"", -1},
+	}
+	for _, tt := range tests {
+		if total := contentRangeTotal(tt.header); total != tt.want {
+			t.Errorf("contentRangeTotal(%q) = %d, want %d", tt.header, total, tt.want)
+		}
+	}
+}
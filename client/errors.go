@@ -0,0 +1,152 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MetaCodeSuccess is the result code meta server returns for a successful call
+const MetaCodeSuccess = "success"
+
+// JSON-RPC 2.0 error codes
+const (
+	jsonRpcInvalidRequest = -32600
+	jsonRpcMethodNotFound = -32601
+	jsonRpcInvalidParams  = -32602
+	jsonRpcInternalError  = -32603
+)
+
+// sentinel errors for the failure kinds of meta server calls, use errors.Is to check them
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrUnauthorized  = errors.New("unauthorized")
+	ErrDatasetExists = errors.New("dataset already exists")
+	ErrInvalidParams = errors.New("invalid params")
+	ErrServer        = errors.New("meta server error")
+)
+
+// MetaError describes a failed meta server JSON-RPC call, use errors.As to get it
+type MetaError struct {
+	Method     string // JSON-RPC method, e.g. meta.StoreSourceFile
+	Code       string // result code, or the JSON-RPC error code
+	Message    string // message returned by meta server
+	HttpStatus int    // http status of the response
+	RequestId  int    // JSON-RPC request id
+}
+
+func (e *MetaError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "meta server %s failed", e.Method)
+	if e.HttpStatus != http.StatusOK {
+		fmt.Fprintf(&sb, ", http status: %d", e.HttpStatus)
+	}
+	if e.Code != "" {
+		fmt.Fprintf(&sb, ", code: %s", e.Code)
+	}
+	if e.Message != "" {
+		fmt.Fprintf(&sb, ", message: %s", e.Message)
+	}
+	fmt.Fprintf(&sb, ", id: %d", e.RequestId)
+	return sb.String()
+}
+
+// Is reports whether the error belongs to the failure kind of target,
+// target should be one of the sentinel errors of this package
+func (e *MetaError) Is(target error) bool {
+	kind := e.kind()
+	return kind != nil && kind == target
+}
+
+// kind classifies the error by http status, JSON-RPC error code and message,
+// ErrServer is only returned for 5xx statuses and JSON-RPC internal errors, nil for an unknown kind
+func (e *MetaError) kind() error {
+	switch {
+	case e.HttpStatus == http.StatusUnauthorized || e.HttpStatus == http.StatusForbidden:
+		return ErrUnauthorized
+	case e.HttpStatus == http.StatusNotFound:
+		return ErrNotFound
+	case e.HttpStatus == http.StatusBadRequest:
+		return ErrInvalidParams
+	case e.HttpStatus >= http.StatusInternalServerError:
+		return ErrServer
+	}
+
+	if code, err := strconv.Atoi(e.Code); err == nil {
+		switch code {
+		case jsonRpcInvalidRequest, jsonRpcInvalidParams:
+			return ErrInvalidParams
+		case jsonRpcMethodNotFound, jsonRpcInternalError:
+			return ErrServer
+		}
+	}
+
+	// the business failures of meta server come with http status 200, only the message tells them apart
+	msg := strings.ToLower(e.Message)
+	switch {
+	case containsAny(msg, "unauthorized", "forbidden", "api key", "api-key",
+		"invalid token", "token invalid", "token is invalid", "token expired", "token is expired", "expired token"):
+		return ErrUnauthorized
+	case containsAny(msg, "already exist", "duplicate"):
+		return ErrDatasetExists
+	case containsAny(msg, "not found", "not exist", "no record"):
+		return ErrNotFound
+	case containsAny(msg, "invalid", "required", "param"):
+		return ErrInvalidParams
+	}
+	// unknown kind, a rejection is not taken for a server error
+	return nil
+}
+
+func containsAny(s string, subs ...string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// SourceError is a failed download from one of the sources of Download
+type SourceError struct {
+	Url string
+	Err error
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Url, e.Err)
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// DownloadError is returned by Download when all the sources fail, use errors.As to get it
+type DownloadError struct {
+	IpfsCid string
+	Errors  []*SourceError // in the order the sources are tried
+}
+
+func (e *DownloadError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("download %s failed: there are no available download sources", e.IpfsCid)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "download %s failed from all %d sources", e.IpfsCid, len(e.Errors))
+	for _, err := range e.Errors {
+		fmt.Fprintf(&sb, "; %v", err)
+	}
+	return sb.String()
+}
+
+// Is reports whether any of the sources failed with target, e.g. ErrCidMismatch
+func (e *DownloadError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
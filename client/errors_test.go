@@ -0,0 +1,35 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestMetaErrorKind(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *MetaError
+		want error
+	}{
+		{"unauthorized status", &MetaError{HttpStatus: http.StatusUnauthorized}, ErrUnauthorized},
+		{"server status", &MetaError{HttpStatus: http.StatusBadGateway, Message: "token expired"}, ErrServer},
+		{"internal error", &MetaError{HttpStatus: http.StatusOK, Code: "-32603"}, ErrServer},
+		{"invalid params code", &MetaError{HttpStatus: http.StatusOK, Code: "-32602"}, ErrInvalidParams},
+		{"expired token", &MetaError{HttpStatus: http.StatusOK, Code: "error", Message: "Token expired"}, ErrUnauthorized},
+		{"token in a message", &MetaError{HttpStatus: http.StatusOK, Code: "error", Message: "token count exceeds the dataset quota"}, nil},
+		{"dataset exists", &MetaError{HttpStatus: http.StatusOK, Code: "error", Message: "dataset already exists"}, ErrDatasetExists},
+		{"not found", &MetaError{HttpStatus: http.StatusOK, Code: "error", Message: "no record of the cid"}, ErrNotFound},
+		{"invalid params", &MetaError{HttpStatus: http.StatusOK, Code: "error", Message: "dataset name is required"}, ErrInvalidParams},
+		{"unknown rejection", &MetaError{HttpStatus: http.StatusOK, Code: "error", Message: "quota exceeded"}, nil},
+		{"unknown status", &MetaError{HttpStatus: http.StatusTeapot}, nil},
+	}
+	sentinels := []error{ErrNotFound, ErrUnauthorized, ErrDatasetExists, ErrInvalidParams, ErrServer}
+	for _, tt := range tests {
+		for _, sentinel := range sentinels {
+			if got := errors.Is(tt.err, sentinel); got != (sentinel == tt.want) {
+				t.Errorf("%s: errors.Is(%v) = %v", tt.name, sentinel, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package client
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// downloadTar streams the directory from the gateway url as a tar and extracts it into target,
+// with verify the tar is checked against ipfsCid while it is extracted, re-chunked with uploadOptions
+// or the parameters guessed from the cid. It returns the extracted files.
+func downloadTar(ctx context.Context, client *http.Client, gatewayUrl, ipfsCid, target string, verify bool, uploadOptions []UploadOptions, progress func(DownloadProgress)) ([]string, error) {
+	var expected cid.Cid
+	var params unixfsParams
+	var guessed bool
+	if verify {
+		var err error
+		if expected, err = cid.Decode(ipfsCid); err != nil {
+			return nil, err
+		}
+		if params, guessed, err = verifyParams(expected, uploadOptions); err != nil {
+			return nil, err
+		}
+	}
+	tarUrl, err := tarRequestUrl(gatewayUrl)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, tarUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, newHttpStatusError(response, tarUrl)
+	}
+
+	// extract into a temporary path, so that target only appears once it is complete
+	if err = os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return nil, err
+	}
+	partial := filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+partSuffix)
+	os.RemoveAll(partial)
+
+	var completed int64
+	var r io.Reader = &countingReader{r: response.Body, n: &completed}
+	total := response.ContentLength
+	if total < 0 {
+		total = 0
+	}
+	lastReport := time.Now()
+	report := func() {
+		if progress != nil && time.Since(lastReport) >= time.Second {
+			lastReport = time.Now()
+			progress(DownloadProgress{Status: Aria2StatusActive, CompletedLength: completed, TotalLength: total, Connections: 1})
+		}
+	}
+
+	// the verification reads the same stream through a pipe
+	var pw *io.PipeWriter
+	var verified chan verifyResult
+	if verify {
+		var pr *io.PipeReader
+		pr, pw = io.Pipe()
+		verified = make(chan verifyResult, 1)
+		go func() {
+			node, err := newDagBuilder(params).addTar(pr)
+			io.Copy(io.Discard, pr)
+			verified <- verifyResult{node: node, err: err}
+		}()
+		r = io.TeeReader(r, pw)
+	}
+
+	extracted, err := extractTar(r, partial, report)
+	if err == nil {
+		// the end of the tar stream is still to be verified
+		_, err = io.Copy(io.Discard, r)
+	}
+	if verify {
+		if err != nil {
+			pw.CloseWithError(err)
+			<-verified
+		} else {
+			pw.Close()
+			res := <-verified
+			switch {
+			case res.err != nil:
+				err = res.err
+			case !res.node.Cid.Equals(expected):
+				err = &CidMismatchError{Path: target, Expected: expected.String(), Actual: res.node.Cid.String(), Guessed: guessed}
+			}
+		}
+	}
+	// with guessed parameters a mismatch does not prove the content is wrong, so it is kept
+	var mismatch *CidMismatchError
+	if err != nil && !(errors.As(err, &mismatch) && mismatch.Guessed) {
+		os.RemoveAll(partial)
+		return nil, err
+	}
+
+	os.RemoveAll(target)
+	if renameErr := os.Rename(partial, target); renameErr != nil {
+		return nil, renameErr
+	}
+	if err != nil {
+		return extracted, err
+	}
+	if progress != nil {
+		progress(DownloadProgress{Status: Aria2StatusComplete, CompletedLength: completed, TotalLength: completed})
+	}
+	return extracted, nil
+}
+
+type verifyResult struct {
+	node dagNode
+	err  error
+}
+
+// extractTar extracts the single top level directory of the tar stream into dir, and returns
+// the slash separated paths of the extracted files and symlinks relative to dir. Entries escaping dir
+// are rejected, the file modes and mtimes are kept, other entry types than directories, regular files
+// and symlinks are skipped.
+func extractTar(r io.Reader, dir string, report func()) ([]string, error) {
+	type dirAttr struct {
+		path  string
+		mode  os.FileMode
+		mtime time.Time
+	}
+	var dirs []dirAttr
+	var extracted []string
+	var root string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if name == "." || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("invalid tar entry %q", hdr.Name)
+		}
+		// the top level entry is the directory itself, named by the cid or the source name
+		top, rel, _ := strings.Cut(name, "/")
+		if root == "" {
+			root = top
+		} else if top != root {
+			return nil, fmt.Errorf("tar should contain exactly one top level entry, found %q and %q", root, top)
+		}
+		if rel == "" && hdr.Typeflag != tar.TypeDir {
+			return nil, fmt.Errorf("tar entry %q is not a directory", hdr.Name)
+		}
+		localPath, err := extractPath(dir, rel)
+		if err != nil {
+			return nil, err
+		}
+
+		mode := hdr.FileInfo().Mode().Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if mode == 0 {
+				mode = 0755
+			}
+			if err = os.MkdirAll(localPath, 0700); err != nil {
+				return nil, err
+			}
+			// set after the children are written, a read-only directory cannot be written into
+			dirs = append(dirs, dirAttr{path: localPath, mode: mode, mtime: hdr.ModTime})
+		case tar.TypeReg, tar.TypeRegA:
+			if mode == 0 {
+				mode = 0644
+			}
+			if err = os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+				return nil, err
+			}
+			if err = writeTarFile(tr, localPath, mode); err != nil {
+				return nil, err
+			}
+			setModTime(localPath, hdr.ModTime)
+			extracted = append(extracted, rel)
+		case tar.TypeSymlink:
+			if err = checkSymlinkTarget(rel, hdr.Linkname); err != nil {
+				return nil, err
+			}
+			if err = os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+				return nil, err
+			}
+			os.Remove(localPath)
+			if err = os.Symlink(hdr.Linkname, localPath); err != nil {
+				return nil, err
+			}
+			extracted = append(extracted, rel)
+		default:
+			log.Printf("skip tar entry %q of type %c", hdr.Name, hdr.Typeflag)
+		}
+		report()
+	}
+	if root == "" {
+		return nil, fmt.Errorf("tar should contain exactly one top level entry, found 0")
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			return nil, err
+		}
+		setModTime(dirs[i].path, dirs[i].mtime)
+	}
+	return extracted, nil
+}
+
+// extractPath returns the local path of the slash separated rel under dir, it is rejected
+// if a parent is a symlink, which could point outside dir
+func extractPath(dir, rel string) (string, error) {
+	if rel == "" {
+		return dir, nil
+	}
+	localPath := dir
+	parts := strings.Split(rel, "/")
+	for i, part := range parts {
+		if err := checkEntryName(part); err != nil {
+			return "", err
+		}
+		localPath = filepath.Join(localPath, part)
+		if i == len(parts)-1 {
+			break
+		}
+		if info, err := os.Lstat(localPath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("tar entry %q is under the symlink %q", rel, strings.Join(parts[:i+1], "/"))
+		}
+	}
+	return localPath, nil
+}
+
+// checkSymlinkTarget rejects a symlink at rel which points outside the extracted directory.
+// The target may only go up at its start, "a/.." is resolved through a, which may be a symlink itself.
+func checkSymlinkTarget(rel, target string) error {
+	if target == "" || path.IsAbs(target) || filepath.IsAbs(target) || strings.Contains(target, "\\") {
+		return fmt.Errorf("symlink %q points outside the directory: %q", rel, target)
+	}
+	depth := strings.Count(rel, "/")
+	down := false
+	for _, part := range strings.Split(target, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if depth--; down || depth < 0 {
+				return fmt.Errorf("symlink %q points outside the directory: %q", rel, target)
+			}
+		default:
+			down = true
+		}
+	}
+	return nil
+}
+
+func writeTarFile(r io.Reader, filePath string, mode os.FileMode) error {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// setModTime sets the mtime from the tar header, gateways which do not keep it send the zero time
+func setModTime(filePath string, mtime time.Time) {
+	if mtime.IsZero() || mtime.Unix() <= 0 {
+		return
+	}
+	if err := os.Chtimes(filePath, mtime, mtime); err != nil {
+		log.Printf("failed to set the mtime of %s: %v", filePath, err)
+	}
+}
@@ -0,0 +1,215 @@
+package client
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// tarEntry is an entry of a test tar stream
+type tarEntry struct {
+	name string
+	typ  byte
+	body string // the content of a regular file, or the target of a symlink
+}
+
+func dirEntry(name string) tarEntry {
+	return tarEntry{name: name, typ: tar.TypeDir}
+}
+
+func fileEntry(name, body string) tarEntry {
+	return tarEntry{name: name, typ: tar.TypeReg, body: body}
+}
+
+func symlinkEntry(name, target string) tarEntry {
+	return tarEntry{name: name, typ: tar.TypeSymlink, body: target}
+}
+
+func encodeTar(t *testing.T, entries ...tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		hdr := &tar.Header{Name: entry.name, Typeflag: entry.typ, Mode: 0644}
+		switch entry.typ {
+		case tar.TypeDir:
+			hdr.Mode = 0755
+		case tar.TypeReg:
+			hdr.Size = int64(len(entry.body))
+		case tar.TypeSymlink:
+			hdr.Linkname = entry.body
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if entry.typ == tar.TypeReg {
+			if _, err := tw.Write([]byte(entry.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractTar(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		want    []string // the extracted files, nil expects an error
+	}{
+		{
+			name: "tree",
+			entries: []tarEntry{
+				dirEntry("root/"), fileEntry("root/a.txt", "a"), dirEntry("root/sub/"), fileEntry("root/sub/b.txt", "b"),
+				symlinkEntry("root/link", "a.txt"), symlinkEntry("root/sub/up", "../a.txt"), symlinkEntry("root/sub/dir", "./../sub"),
+			},
+			want: []string{"a.txt", "link", "sub/b.txt", "sub/dir", "sub/up"},
+		},
+		{
+			name:    "parents without headers",
+			entries: []tarEntry{dirEntry("root/"), fileEntry("root/x/y/z.txt", "z")},
+			want:    []string{"x/y/z.txt"},
+		},
+		{
+			name:    "absolute names",
+			entries: []tarEntry{dirEntry("/root/"), fileEntry("/root/a.txt", "a")},
+			want:    []string{"a.txt"},
+		},
+		{name: "parent entry", entries: []tarEntry{dirEntry("root/"), fileEntry("root/../../escaped", "x")}},
+		{name: "top level parent", entries: []tarEntry{dirEntry("../")}},
+		{name: "backslash", entries: []tarEntry{dirEntry("root/"), fileEntry(`root/..\escaped`, "x")}},
+		{name: "two top level entries", entries: []tarEntry{dirEntry("a/"), dirEntry("b/")}},
+		{name: "top level file", entries: []tarEntry{fileEntry("a.txt", "a")}},
+		{name: "empty", entries: nil},
+		{
+			name:    "entry under a symlink",
+			entries: []tarEntry{dirEntry("root/"), dirEntry("root/sub/"), symlinkEntry("root/link", "sub"), fileEntry("root/link/x.txt", "x")},
+		},
+		{name: "absolute symlink", entries: []tarEntry{dirEntry("root/"), symlinkEntry("root/link", "/etc")}},
+		{name: "escaping symlink", entries: []tarEntry{dirEntry("root/"), symlinkEntry("root/sub/link", "../../escaped")}},
+		{name: "symlink up through a child", entries: []tarEntry{dirEntry("root/"), symlinkEntry("root/link", "sub/../../escaped")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			dir := filepath.Join(base, "out", "root")
+			extracted, err := extractTar(bytes.NewReader(encodeTar(t, tt.entries...)), dir, func() {})
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("no error, extracted %v", extracted)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				sort.Strings(extracted)
+				if !reflect.DeepEqual(extracted, tt.want) {
+					t.Errorf("extracted %v, want %v", extracted, tt.want)
+				}
+			}
+
+			entries, err := os.ReadDir(base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) > 1 || (len(entries) == 1 && entries[0].Name() != "out") {
+				t.Errorf("entries are written outside of the directory: %v", entries)
+			}
+		})
+	}
+}
+
+func TestCheckSymlinkTarget(t *testing.T) {
+	tests := []struct {
+		rel, target string
+		ok          bool
+	}{
+		{"link", "a.txt", true},
+		{"link", "./sub/a.txt", true},
+		{"sub/link", "../a.txt", true},
+		{"a/b/link", "../../c", true},
+		{"link", "", false},
+		{"link", "..", false},
+		{"link", "/etc/passwd", false},
+		{"sub/link", "../../a.txt", false},
+		{"link", "sub/../a.txt", false},
+		{"link", `..\a.txt`, false},
+	}
+	for _, tt := range tests {
+		if err := checkSymlinkTarget(tt.rel, tt.target); (err == nil) != tt.ok {
+			t.Errorf("checkSymlinkTarget(%q, %q) = %v, want ok %v", tt.rel, tt.target, err, tt.ok)
+		}
+	}
+}
+
+func TestDownloadTar(t *testing.T) {
+	stream := encodeTar(t, dirEntry("root/"), fileEntry("root/a.txt", "hello world"), dirEntry("root/sub/"), fileEntry("root/sub/b.txt", "b"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("format") != "tar" {
+			http.Error(w, "not a tar request", http.StatusBadRequest)
+			return
+		}
+		w.Write(stream)
+	}))
+	defer server.Close()
+
+	root, err := newDagBuilder(defaultUnixfsParams()).addTar(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptyDir := "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn"
+	cidVersion := 0
+
+	tests := []struct {
+		name          string
+		ipfsCid       string
+		verify        bool
+		uploadOptions []UploadOptions
+		wantErr       error
+		wantTarget    bool
+	}{
+		{name: "unverified", ipfsCid: emptyDir, wantTarget: true},
+		{name: "verified", ipfsCid: root.Cid.String(), verify: true, wantTarget: true},
+		{name: "verified with options", ipfsCid: root.Cid.String(), verify: true, uploadOptions: []UploadOptions{{CidVersion: &cidVersion}}, wantTarget: true},
+		// the content may be added with other options than the guessed ones, so it is kept
+		{name: "guessed mismatch", ipfsCid: emptyDir, verify: true, wantErr: ErrCidMismatch, wantTarget: true},
+		{name: "mismatch", ipfsCid: emptyDir, verify: true, uploadOptions: []UploadOptions{{}}, wantErr: ErrCidMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "out")
+			extracted, err := downloadTar(context.Background(), server.Client(), server.URL+"/ipfs/"+tt.ipfsCid, tt.ipfsCid, target, tt.verify, tt.uploadOptions, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			got, readErr := os.ReadFile(filepath.Join(target, "a.txt"))
+			if !tt.wantTarget {
+				if !os.IsNotExist(readErr) {
+					t.Errorf("target is kept: %v", readErr)
+				}
+				return
+			}
+			if readErr != nil || string(got) != "hello world" {
+				t.Errorf("a.txt = %q, %v", got, readErr)
+			}
+			sort.Strings(extracted)
+			if want := []string{"a.txt", "sub/b.txt"}; !reflect.DeepEqual(extracted, want) {
+				t.Errorf("extracted %v, want %v", extracted, want)
+			}
+			if _, err = os.Stat(filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+partSuffix)); !os.IsNotExist(err) {
+				t.Errorf("partial directory is left: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,383 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	ignore "github.com/crackcomm/go-gitignore"
+	files "github.com/ipfs/go-ipfs-files"
+)
+
+// SymlinkPolicy is how the symlinks in a directory are uploaded
+type SymlinkPolicy int
+
+const (
+	SymlinkPreserve SymlinkPolicy = iota // upload the symlink itself, like ipfs does
+	SymlinkFollow                        // upload the file or directory the symlink points to
+	SymlinkSkip                          // skip the symlink
+)
+
+// uploadEntry is a file, directory or symlink selected for a directory upload
+type uploadEntry struct {
+	name     string
+	path     string // local path, or the path in fsys
+	fsys     fs.FS  // the entry is read from fsys if it is not nil
+	mode     os.FileMode
+	size     int64
+	target   string // of the symlink
+	children []*uploadEntry
+}
+
+// uploadFilter selects the entries of a directory upload, and records the skipped paths
+type uploadFilter struct {
+	include     *ignore.GitIgnore
+	exclude     *ignore.GitIgnore
+	ignoreFiles []string
+	hidden      bool
+	symlinks    SymlinkPolicy
+
+	skipped []string
+}
+
+// ignoreMatcher is an ignore file which applies to the paths under base
+type ignoreMatcher struct {
+	base string
+	g    *ignore.GitIgnore
+}
+
+func newUploadFilter(opt *uploadOption) *uploadFilter {
+	f := &uploadFilter{
+		ignoreFiles: opt.IgnoreFiles,
+		hidden:      opt.Hidden,
+		symlinks:    opt.Symlinks,
+	}
+	if len(opt.Include) > 0 {
+		f.include, _ = ignore.CompileIgnoreLines(opt.Include...)
+	}
+	if len(opt.Exclude) > 0 {
+		f.exclude, _ = ignore.CompileIgnoreLines(opt.Exclude...)
+	}
+	return f
+}
+
+// walk returns the tree of the entries selected under the directory root
+func (f *uploadFilter) walk(root string) (*uploadEntry, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	entry := &uploadEntry{name: filepath.Base(root), path: root, mode: info.Mode()}
+	visited := map[string]bool{}
+	if f.symlinks == SymlinkFollow {
+		real, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return nil, err
+		}
+		visited[real] = true
+	}
+	return entry, f.walkDir(entry, "", nil, visited)
+}
+
+// walkDir adds the selected children of the directory, rel is its slash separated path relative to the root
+func (f *uploadFilter) walkDir(dir *uploadEntry, rel string, matchers []ignoreMatcher, visited map[string]bool) error {
+	entries, err := os.ReadDir(dir.path)
+	if err != nil {
+		return err
+	}
+	for _, name := range f.ignoreFiles {
+		g, err := ignore.CompileIgnoreFile(filepath.Join(dir.path, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		matchers = append(matchers[:len(matchers):len(matchers)], ignoreMatcher{base: rel, g: g})
+	}
+
+	for _, e := range entries {
+		childPath := filepath.Join(dir.path, e.Name())
+		childRel := path.Join(rel, e.Name())
+		if !f.hidden && isHidden(e.Name()) {
+			f.skip(childPath)
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		child := &uploadEntry{name: e.Name(), path: childPath, mode: info.Mode(), size: info.Size()}
+		if info.Mode()&os.ModeSymlink != 0 {
+			switch f.symlinks {
+			case SymlinkSkip:
+				f.skip(childPath)
+				continue
+			case SymlinkFollow:
+				if info, err = os.Stat(childPath); err != nil {
+					// broken symlink
+					f.skip(childPath)
+					continue
+				}
+				child.mode, child.size = info.Mode(), info.Size()
+			default:
+				if child.target, err = os.Readlink(childPath); err != nil {
+					return err
+				}
+			}
+		}
+
+		isDir := child.mode.IsDir()
+		if f.excluded(childRel, isDir, matchers) {
+			f.skip(childPath)
+			continue
+		}
+		switch {
+		case isDir:
+			if f.symlinks == SymlinkFollow {
+				real, err := filepath.EvalSymlinks(childPath)
+				if err != nil {
+					return err
+				}
+				if visited[real] {
+					// symlink loop
+					f.skip(childPath)
+					continue
+				}
+				visited[real] = true
+				err = f.walkDir(child, childRel, matchers, visited)
+				delete(visited, real)
+				if err != nil {
+					return err
+				}
+			} else if err = f.walkDir(child, childRel, matchers, visited); err != nil {
+				return err
+			}
+			if f.emptied(child) {
+				f.skip(childPath)
+				continue
+			}
+		case child.mode.IsRegular() || child.mode&os.ModeSymlink != 0:
+			if f.include != nil && !f.include.MatchesPath(childRel) {
+				f.skip(childPath)
+				continue
+			}
+		default:
+			// sockets, pipes and devices cannot be uploaded
+			f.skip(childPath)
+			continue
+		}
+		dir.children = append(dir.children, child)
+	}
+	return nil
+}
+
+// walkFS returns the tree of the entries selected under the directory dir of fsys, named name.
+// Symlinks cannot be read from fs.FS, they are skipped like the other entries which are not regular files or directories.
+func (f *uploadFilter) walkFS(fsys fs.FS, dir, name string) (*uploadEntry, error) {
+	info, err := fs.Stat(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	entry := &uploadEntry{name: name, path: dir, fsys: fsys, mode: info.Mode()}
+	return entry, f.walkFSDir(entry, "", nil)
+}
+
+// walkFSDir adds the selected children of the directory in fs.FS, rel is its slash separated path relative to the root
+func (f *uploadFilter) walkFSDir(dir *uploadEntry, rel string, matchers []ignoreMatcher) error {
+	entries, err := fs.ReadDir(dir.fsys, dir.path)
+	if err != nil {
+		return err
+	}
+	for _, name := range f.ignoreFiles {
+		data, err := fs.ReadFile(dir.fsys, path.Join(dir.path, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		g, err := ignore.CompileIgnoreLines(strings.Split(string(data), "\n")...)
+		if err != nil {
+			return err
+		}
+		matchers = append(matchers[:len(matchers):len(matchers)], ignoreMatcher{base: rel, g: g})
+	}
+
+	for _, e := range entries {
+		childPath := path.Join(dir.path, e.Name())
+		childRel := path.Join(rel, e.Name())
+		if !f.hidden && isHidden(e.Name()) {
+			f.skip(childPath)
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		child := &uploadEntry{name: e.Name(), path: childPath, fsys: dir.fsys, mode: info.Mode(), size: info.Size()}
+		isDir := child.mode.IsDir()
+		if f.excluded(childRel, isDir, matchers) {
+			f.skip(childPath)
+			continue
+		}
+		switch {
+		case isDir:
+			if err = f.walkFSDir(child, childRel, matchers); err != nil {
+				return err
+			}
+			if f.emptied(child) {
+				f.skip(childPath)
+				continue
+			}
+		case child.mode.IsRegular():
+			if f.include != nil && !f.include.MatchesPath(childRel) {
+				f.skip(childPath)
+				continue
+			}
+		default:
+			f.skip(childPath)
+			continue
+		}
+		dir.children = append(dir.children, child)
+	}
+	return nil
+}
+
+// emptied reports whether the include patterns leave no entry in the directory,
+// which is dropped then instead of being uploaded empty
+func (f *uploadFilter) emptied(dir *uploadEntry) bool {
+	return f.include != nil && len(dir.children) == 0
+}
+
+// excluded reports whether the exclude patterns or an ignore file matches the path
+func (f *uploadFilter) excluded(rel string, isDir bool, matchers []ignoreMatcher) bool {
+	match := func(g *ignore.GitIgnore, p string) bool {
+		if isDir {
+			return g.MatchesPath(p) || g.MatchesPath(p+"/")
+		}
+		return g.MatchesPath(p)
+	}
+	if f.exclude != nil && match(f.exclude, rel) {
+		return true
+	}
+	for _, m := range matchers {
+		p := rel
+		if m.base != "" {
+			p = strings.TrimPrefix(rel, m.base+"/")
+		}
+		if match(m.g, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *uploadFilter) skip(filePath string) {
+	f.skipped = append(f.skipped, filePath)
+}
+
+// entryDirectory is the files.Directory of the selected entries of a directory
+type entryDirectory struct {
+	entry *uploadEntry
+}
+
+func (d *entryDirectory) Entries() files.DirIterator {
+	return &entryIterator{children: d.entry.children}
+}
+
+func (d *entryDirectory) Size() (int64, error) {
+	return d.entry.fileSize(), nil
+}
+
+func (d *entryDirectory) Close() error {
+	return nil
+}
+
+type entryIterator struct {
+	children []*uploadEntry
+	child    *uploadEntry
+	node     files.Node
+	err      error
+}
+
+func (it *entryIterator) Name() string {
+	return it.child.name
+}
+
+func (it *entryIterator) Node() files.Node {
+	return it.node
+}
+
+func (it *entryIterator) Next() bool {
+	if it.err != nil || len(it.children) == 0 {
+		return false
+	}
+	it.child = it.children[0]
+	it.children = it.children[1:]
+
+	switch {
+	case it.child.mode.IsDir():
+		it.node = &entryDirectory{entry: it.child}
+	case it.child.mode&os.ModeSymlink != 0:
+		it.node = files.NewLinkFile(it.child.target, nil)
+	case it.child.fsys != nil:
+		file, err := it.child.fsys.Open(it.child.path)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			it.err = err
+			return false
+		}
+		it.node = files.NewReaderStatFile(file, info)
+	default:
+		file, err := os.Open(it.child.path)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.node = files.NewReaderFile(file)
+	}
+	return true
+}
+
+func (it *entryIterator) Err() error {
+	return it.err
+}
+
+// fileSize returns the total size of the regular files under the entry
+func (e *uploadEntry) fileSize() int64 {
+	if e.mode.IsRegular() {
+		return e.size
+	}
+	var size int64
+	for _, child := range e.children {
+		size += child.fileSize()
+	}
+	return size
+}
+
+// fileCount returns the count of the regular files under the entry
+func (e *uploadEntry) fileCount() int {
+	if e.mode.IsRegular() {
+		return 1
+	}
+	var count int
+	for _, child := range e.children {
+		count += child.fileCount()
+	}
+	return count
+}
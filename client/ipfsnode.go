@@ -0,0 +1,179 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	shell "github.com/ipfs/go-ipfs-api"
+)
+
+// IpfsApiSelection is the order the ipfs apis of MetaConf are tried in
+type IpfsApiSelection int
+
+const (
+	IpfsApiPriority   IpfsApiSelection = iota // IpfsApi first, then IpfsApis in order
+	IpfsApiRoundRobin                         // start with the next api on each call
+)
+
+const (
+	// ipfsHealthInterval is how long the result of a health check is used
+	ipfsHealthInterval = 30 * time.Second
+	// ipfsHealthTimeout limits the health check request
+	ipfsHealthTimeout = 5 * time.Second
+)
+
+// ipfsNode is an ipfs api with the shell shared by its requests
+type ipfsNode struct {
+	api     string
+	sh      *shell.Shell
+	checked time.Time // of the last health check or failed request
+	healthy bool
+}
+
+// ipfsNodes are the ipfs apis of MetaConf, they are rebuilt when the apis change
+type ipfsNodes struct {
+	apis  string
+	nodes []*ipfsNode
+	next  int // for IpfsApiRoundRobin
+}
+
+// ipfsApis returns IpfsApi and IpfsApis without the empty and repeated urls
+func (c *MetaConf) ipfsApis() []string {
+	if c == nil {
+		return nil
+	}
+	var apis []string
+	seen := map[string]bool{}
+	for _, api := range append([]string{c.IpfsApi}, c.IpfsApis...) {
+		if api != "" && !seen[api] {
+			seen[api] = true
+			apis = append(apis, api)
+		}
+	}
+	return apis
+}
+
+// ipfsNodesToTry returns the ipfs nodes in the order of MetaConf.IpfsApiSelection, the nodes which
+// fail the health check are left out. A single node is not checked, there is nothing to fail over to.
+func (m *MetaClient) ipfsNodesToTry(ctx context.Context) ([]*ipfsNode, error) {
+	return m.healthyIpfsNodes(ctx, m.conf.IpfsApiSelection == IpfsApiRoundRobin, false)
+}
+
+// primaryIpfsNode returns the first node in the order of IpfsApiPriority which passes the health check,
+// whatever MetaConf.IpfsApiSelection is. The requests which depend on each other, e.g. the pins, are sent
+// to it rather than failed over, so consecutive calls reach the same node while it is available.
+func (m *MetaClient) primaryIpfsNode(ctx context.Context) (*ipfsNode, error) {
+	nodes, err := m.healthyIpfsNodes(ctx, false, true)
+	if err != nil {
+		return nil, err
+	}
+	return nodes[0], nil
+}
+
+// healthyIpfsNodes returns the ipfs nodes which pass the health check, starting with the next one
+// if rotate is set, and only the first healthy one if first is set
+func (m *MetaClient) healthyIpfsNodes(ctx context.Context, rotate, first bool) ([]*ipfsNode, error) {
+	apis := m.conf.ipfsApis()
+	if len(apis) == 0 {
+		return nil, errors.New("ipfs api is required")
+	}
+
+	m.mu.Lock()
+	key := strings.Join(apis, "\n")
+	if m.nodes == nil || m.nodes.apis != key {
+		m.nodes = &ipfsNodes{apis: key}
+		for _, api := range apis {
+			m.nodes.nodes = append(m.nodes.nodes, &ipfsNode{api: api, sh: shell.NewShell(api)})
+		}
+	}
+	nodes := append([]*ipfsNode(nil), m.nodes.nodes...)
+	if rotate {
+		start := m.nodes.next % len(nodes)
+		m.nodes.next = start + 1
+		nodes = append(nodes[start:], nodes[:start]...)
+	}
+	m.mu.Unlock()
+	if len(nodes) == 1 {
+		return nodes, nil
+	}
+
+	var healthy []*ipfsNode
+	for _, node := range nodes {
+		if m.checkIpfsNode(ctx, node) {
+			healthy = append(healthy, node)
+			if first {
+				break
+			}
+		}
+	}
+	if len(healthy) == 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("no ipfs api is available, tried %s", strings.Join(apis, ", "))
+	}
+	return healthy, nil
+}
+
+// checkIpfsNode reports whether the node answers the version request, the result is kept for ipfsHealthInterval
+func (m *MetaClient) checkIpfsNode(ctx context.Context, node *ipfsNode) bool {
+	m.mu.Lock()
+	if time.Since(node.checked) < ipfsHealthInterval {
+		defer m.mu.Unlock()
+		return node.healthy
+	}
+	m.mu.Unlock()
+
+	checkCtx, cancel := context.WithTimeout(ctx, ipfsHealthTimeout)
+	defer cancel()
+	err := node.sh.Request("version").Exec(checkCtx, nil)
+	if err != nil && ctx.Err() != nil {
+		// not the fault of the node
+		return false
+	}
+	if err != nil {
+		log.Printf("ipfs api %s is not available: %v", node.api, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	node.checked, node.healthy = time.Now(), err == nil
+	return node.healthy
+}
+
+// withIpfsFailover calls f with the ipfs nodes in turn, until it does not fail because the node cannot be reached
+func (m *MetaClient) withIpfsFailover(ctx context.Context, f func(node *ipfsNode) error) error {
+	nodes, err := m.ipfsNodesToTry(ctx)
+	if err != nil {
+		return err
+	}
+	for i, node := range nodes {
+		if err = f(node); err == nil || !isIpfsNodeError(ctx, err) {
+			return err
+		}
+		m.mu.Lock()
+		node.checked, node.healthy = time.Now(), false
+		m.mu.Unlock()
+		if i < len(nodes)-1 {
+			log.Printf("ipfs api %s failed, fail over to %s: %v", node.api, nodes[i+1].api, err)
+		}
+	}
+	return err
+}
+
+// isIpfsNodeError reports whether the request failed as the ipfs node cannot be reached,
+// unlike the errors returned by the node or the ones reading the local files
+func isIpfsNodeError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var urlErr *url.Error
+	var pathErr *fs.PathError
+	return errors.As(err, &urlErr) && !errors.As(err, &pathErr)
+}
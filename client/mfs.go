@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	shell "github.com/ipfs/go-ipfs-api"
+)
+
+// MfsConflict is how an upload is placed in MFS when the entry name is taken by other data
+type MfsConflict int
+
+const (
+	MfsConflictError     MfsConflict = iota // fail the upload
+	MfsConflictOverwrite                    // replace the existing entry
+	MfsConflictRename                       // add a -1, -2, ... suffix to the name
+)
+
+// maxMfsRenames limits the suffixes tried by MfsConflictRename
+const maxMfsRenames = 1000
+
+// mfsPlacement is where placeInMfs keeps the upload, and what it added for it
+type mfsPlacement struct {
+	mfsPath    string
+	mfsCreated bool // the MFS entry is copied by this upload, not an existing one reused
+	pinAdded   bool // the recursive pin is added by this upload, the cid was not pinned before
+}
+
+// placeInMfs pins the upload unless PinMfs is given, and copies it into MFS under WithMfsDir with WithMfsName
+// unless PinRecursive is given. An entry which already holds the same cid is reused, and so is an existing pin.
+func placeInMfs(ctx context.Context, sh *shell.Shell, ipfsCid string, opt *uploadOption) (placement mfsPlacement, err error) {
+	if opt.Add.OnlyHash {
+		return
+	}
+	// the data is added without a pin, so it is known whether the upload adds the pin
+	if opt.pinned() {
+		if placement.pinAdded, err = pinIfNot(ctx, sh, ipfsCid); err != nil {
+			return
+		}
+	}
+	if opt.PinMode == PinRecursive {
+		return
+	}
+
+	dir := opt.MfsDir
+	if dir == "" {
+		dir = "/"
+	}
+	dir = path.Clean("/" + dir)
+	if dir != "/" {
+		if err = sh.FilesMkdir(ctx, dir, shell.FilesMkdir.Parents(true)); err != nil {
+			return
+		}
+	}
+	name := opt.MfsName
+	if name == "" {
+		name = ipfsCid
+	}
+	if strings.Contains(name, "/") {
+		err = fmt.Errorf("invalid MFS name %s", name)
+		return
+	}
+
+	target := path.Join(dir, name)
+	for i := 1; ; i++ {
+		stat, statErr := sh.FilesStat(ctx, target)
+		if statErr != nil {
+			if !isMfsNotExist(statErr) {
+				err = statErr
+				return
+			}
+			break
+		}
+		if stat.Hash == ipfsCid {
+			placement.mfsPath = target
+			return
+		}
+
+		switch opt.MfsConflict {
+		case MfsConflictOverwrite:
+			if err = sh.FilesRm(ctx, target, true); err != nil {
+				return
+			}
+		case MfsConflictRename:
+			if i > maxMfsRenames {
+				err = fmt.Errorf("no free MFS name for %s", path.Join(dir, name))
+				return
+			}
+			ext := path.Ext(name)
+			target = path.Join(dir, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), i, ext))
+			continue
+		default:
+			err = fmt.Errorf("%s already exists in MFS with %s", target, stat.Hash)
+			return
+		}
+		break
+	}
+
+	if err = sh.FilesCp(ctx, PathJoin("/ipfs/", ipfsCid), target); err != nil {
+		return
+	}
+	placement.mfsPath, placement.mfsCreated = target, true
+	return
+}
+
+// isMfsNotExist reports whether the MFS request failed as the path does not exist
+func isMfsNotExist(err error) bool {
+	return strings.Contains(err.Error(), "does not exist")
+}
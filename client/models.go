@@ -1,10 +1,24 @@
 package client
 
+import "time"
+
 type MetaConf struct {
 	MetaServer  string
 	IpfsApi     string     // for upload
+	IpfsApis    []string   // for upload, more ipfs apis to fail over to, see IpfsApiSelection
 	IpfsGateway string     // for download
 	Aria2Conf   *Aria2Conf // for download
+	Downloader  Downloader // for download, default aria2 if Aria2Conf is set, otherwise http
+
+	PublicGateways []string // for download, tried after IpfsGateway, e.g. DefaultPublicGateways
+
+	IpfsApiSelection IpfsApiSelection // for upload, the order IpfsApi and IpfsApis are tried, default IpfsApiPriority
+
+	BackupRetries       int           // for UploadAndBackup, retries of a failed backup, default 3, negative for none
+	BackupRetryInterval time.Duration // for UploadAndBackup, wait between the retries, default 1s
+	RollbackOnFailure   bool          // for UploadAndBackup, remove the uploads from MFS if they cannot be backed up
+
+	PinningServices []PinningService // for WithRemotePins, remote pinning services replicating the uploads
 }
 
 type Aria2Conf struct {
@@ -20,6 +34,22 @@ type JsonRpcParams struct {
 	Id      int           `json:"id"`
 }
 
+// MetaResponse is the common envelope of meta server JSON-RPC responses
+type MetaResponse struct {
+	JsonRpc string `json:"jsonrpc"`
+	Result  struct {
+		Code    string `json:"code"`
+		Message string `json:"message,omitempty"`
+	} `json:"result"`
+	Error *JsonRpcError `json:"error,omitempty"`
+	Id    int           `json:"id"`
+}
+
+type JsonRpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
 type IpfsCidInfo struct {
 	IpfsCid     string `json:"ipfs_cid"`
 	DataSize    int64  `json:"data_size"`
@@ -32,9 +62,21 @@ type IpfsCidInfo struct {
 type IpfsData struct {
 	IpfsCid     string `json:"ipfs_cid"`
 	SourceName  string `json:"source_name"`
-	DataSize    int64  `json:"data_size"`
+	DataSize    int64  `json:"data_size"` // total size of the file content, recursive for directories
 	IsDirectory bool   `json:"is_directory"`
 	DownloadUrl string `json:"download_url"`
+
+	MfsPath   string   `json:"-"` // MFS path the upload is copied to, empty for PinRecursive or if only the hash is computed
+	Pinned    bool     `json:"-"` // whether the upload is pinned recursively
+	IpfsApi   string   `json:"-"` // ipfs api the upload is added to
+	FileCount int      `json:"-"` // count of the uploaded files
+	DagSize   int64    `json:"-"` // cumulative size of the DAG blocks, set with WithDagSize or by DryRun
+	Skipped   []string `json:"-"` // paths skipped by the directory upload filters
+
+	RemotePins []RemotePin `json:"-"` // pin requests to the remote pinning services, set with WithRemotePins
+
+	mfsCreated bool // MfsPath is copied by the upload, not an entry of an earlier upload reused
+	pinAdded   bool // the pin is added by the upload, the cid was not pinned before
 }
 
 type StoreSourceFileResponse struct {
@@ -199,3 +241,315 @@ func defaultOptions() listOption {
 		ShowCar: false,
 	}
 }
+
+type DownloadProgress struct {
+	Gid             string // aria2 gid of the download, empty for other downloaders
+	Status          string // aria2 status: active, waiting, paused, error, complete or removed
+	CompletedLength int64  // downloaded bytes
+	TotalLength     int64  // total bytes, 0 if it is unknown yet
+	DownloadSpeed   int64  // bytes per second
+	Connections     int    // connections to the source
+}
+
+// download option
+type downloadOption struct {
+	DownloadUrl  string
+	Wait         bool
+	PollInterval time.Duration
+	Progress     func(DownloadProgress)
+	Verify       bool
+	VerifyParams []UploadOptions // the upload parameters to verify with, guessed from the cid if empty
+	Car          bool
+	Result       *DownloadResult
+	Extract      bool
+	SubPath      string
+}
+
+type DownloadOption interface {
+	apply(*downloadOption)
+}
+
+type funcDownloadOption struct {
+	f func(*downloadOption)
+}
+
+func (fdo *funcDownloadOption) apply(do *downloadOption) {
+	fdo.f(do)
+}
+
+func newFuncDownloadOption(f func(*downloadOption)) *funcDownloadOption {
+	return &funcDownloadOption{
+		f: f,
+	}
+}
+
+// WithDownloadUrl downloads the specific url instead of the links from meta server
+func WithDownloadUrl(url string) DownloadOption {
+	return newFuncDownloadOption(func(o *downloadOption) {
+		o.DownloadUrl = url
+	})
+}
+
+// WithWait blocks Download until aria2 completes or fails the download, other downloaders always block
+func WithWait(wait bool) DownloadOption {
+	return newFuncDownloadOption(func(o *downloadOption) {
+		o.Wait = wait
+	})
+}
+
+// WithPollInterval sets how often the aria2 download status is polled while waiting, instead of the PollInterval of the downloader
+func WithPollInterval(interval time.Duration) DownloadOption {
+	return newFuncDownloadOption(func(o *downloadOption) {
+		if interval > 0 {
+			o.PollInterval = interval
+		}
+	})
+}
+
+// WithProgress reports the download progress to f on every poll, it implies WithWait(true)
+func WithProgress(f func(DownloadProgress)) DownloadOption {
+	return newFuncDownloadOption(func(o *downloadOption) {
+		o.Progress = f
+		o.Wait = f != nil || o.Wait
+	})
+}
+
+// WithVerify checks the downloaded content against the ipfs cid, it implies WithWait(true).
+// The content is re-chunked with the UploadOptions it was uploaded with, or the ones guessed from the cid if not given.
+// *CidMismatchError is returned if it does not match, the content is removed unless the parameters are guessed.
+func WithVerify(verify bool, uploadOptions ...UploadOptions) DownloadOption {
+	return newFuncDownloadOption(func(o *downloadOption) {
+		o.Verify, o.VerifyParams = verify, uploadOptions
+		o.Wait = verify || o.Wait
+	})
+}
+
+// WithCar retrieves the content as a CAR and verifies every block against its cid while streaming,
+// so untrusted gateways can be used. The file or directory is written to outPath without tar,
+// and the configured Downloader is not used.
+func WithCar(car bool) DownloadOption {
+	return newFuncDownloadOption(func(o *downloadOption) {
+		o.Car = car
+	})
+}
+
+// WithExtract streams a directory as a tar from the gateway and extracts it into outPath/<name>/ instead of
+// saving <name>.tar, the configured Downloader is not used
+func WithExtract(extract bool) DownloadOption {
+	return newFuncDownloadOption(func(o *downloadOption) {
+		o.Extract = extract
+	})
+}
+
+// WithSubPath downloads only the file or directory at the slash separated path under the ipfs cid, e.g. "images/2023/".
+// Its type is resolved with the ipfs api if it is configured, otherwise a path ending with a slash is a directory.
+func WithSubPath(subPath string) DownloadOption {
+	return newFuncDownloadOption(func(o *downloadOption) {
+		o.SubPath = subPath
+	})
+}
+
+// WithDownloadResult fills result with the source Download succeeds with, and the errors of the sources tried before
+func WithDownloadResult(result *DownloadResult) DownloadOption {
+	return newFuncDownloadOption(func(o *downloadOption) {
+		o.Result = result
+	})
+}
+
+// defaultDownloadOptions leaves PollInterval to the downloader, which polls every second by default
+func defaultDownloadOptions() downloadOption {
+	return downloadOption{}
+}
+
+// upload option
+type uploadOption struct {
+	Resumable   bool
+	Progress    func(UploadProgress)
+	Add         UploadOptions
+	Include     []string
+	Exclude     []string
+	IgnoreFiles []string
+	Hidden      bool
+	Symlinks    SymlinkPolicy
+	DagSize     bool
+	Concurrency int
+	Backup      string
+	MfsDir      string
+	MfsName     string
+	MfsConflict MfsConflict
+	PinMode     PinMode
+
+	RemotePin         bool
+	RemotePinServices []string
+}
+
+// pinned reports whether the upload is pinned
+func (o *uploadOption) pinned() bool {
+	return !o.Add.OnlyHash && o.PinMode != PinMfs
+}
+
+type UploadOption interface {
+	apply(*uploadOption)
+}
+
+type funcUploadOption struct {
+	f func(*uploadOption)
+}
+
+func (fuo *funcUploadOption) apply(uo *uploadOption) {
+	fuo.f(uo)
+}
+
+func newFuncUploadOption(f func(*uploadOption)) *funcUploadOption {
+	return &funcUploadOption{
+		f: f,
+	}
+}
+
+// WithResumable chunks the file locally and adds the blocks one by one, the progress is kept
+// in a journal next to the file, so an interrupted upload skips the added chunks when it is retried.
+// Only files are supported, and UploadOptions are limited to the size-N chunker and the balanced layout.
+func WithResumable(resumable bool) UploadOption {
+	return newFuncUploadOption(func(o *uploadOption) {
+		o.Resumable = resumable
+	})
+}
+
+// WithUploadProgress reports the upload progress to f whenever ipfs adds more data or finishes a file
+func WithUploadProgress(f func(UploadProgress)) UploadOption {
+	return newFuncUploadOption(func(o *uploadOption) {
+		o.Progress = f
+	})
+}
+
+// WithInclude uploads only the files of a directory which match one of the gitignore style patterns,
+// the patterns are matched against the path relative to the directory. The directories left without files are skipped.
+func WithInclude(patterns ...string) UploadOption {
+	return newFuncUploadOption(func(o *uploadOption) {
+		o.Include = append(o.Include, patterns...)
+	})
+}
+
+// WithExclude skips the files and directories which match one of the gitignore style patterns,
+// the patterns are matched against the path relative to the directory
+func WithExclude(patterns ...string) UploadOption {
+	return newFuncUploadOption(func(o *uploadOption) {
+		o.Exclude = append(o.Exclude, patterns...)
+	})
+}
+
+// WithIgnoreFiles honors the ignore files with the names, e.g. .gitignore and .ipfsignore,
+// each of them applies to the directory it is in like .gitignore does
+func WithIgnoreFiles(names ...string) UploadOption {
+	return newFuncUploadOption(func(o *uploadOption) {
+		o.IgnoreFiles = append(o.IgnoreFiles, names...)
+	})
+}
+
+// WithHidden uploads the hidden files of a directory too, which are skipped by default
+func WithHidden(hidden bool) UploadOption {
+	return newFuncUploadOption(func(o *uploadOption) {
+		o.Hidden = hidden
+	})
+}
+
+// WithSymlinks sets how the symlinks in a directory are uploaded, default SymlinkPreserve
+func WithSymlinks(policy SymlinkPolicy) UploadOption {
+	return newFuncUploadOption(func(o *uploadOption) {
+		o.Symlinks = policy
+	})
+}
+
+// WithDagSize fetches the cumulative size of the uploaded DAG from ipfs into IpfsData.DagSize
+func WithDagSize(dagSize bool) UploadOption {
+	return newFuncUploadOption(func(o *uploadOption) {
+		o.DagSize = dagSize
+	})
+}
+
+// WithConcurrency sets how many paths UploadMany uploads at the same time, default 4
+func WithConcurrency(n int) UploadOption {
+	return newFuncUploadOption(func(o *uploadOption) {
+		if n > 0 {
+			o.Concurrency = n
+		}
+	})
+}
+
+// WithBackup backups the successful uploads of UploadMany with the datasetName in a single Backup call
+func WithBackup(datasetName string) UploadOption {
+	return newFuncUploadOption(func(o *uploadOption) {
+		o.Backup = datasetName
+	})
+}
+
+// WithMfsDir copies the uploads into the MFS directory instead of the root, the parents are created as needed
+func WithMfsDir(dir string) UploadOption {
+	return newFuncUploadOption(func(o *uploadOption) {
+		o.MfsDir = dir
+	})
+}
+
+// WithMfsName sets the MFS entry name of the upload, default the cid
+func WithMfsName(name string) UploadOption {
+	return newFuncUploadOption(func(o *uploadOption) {
+		o.MfsName = name
+	})
+}
+
+// WithMfsConflict sets what to do when the MFS entry name is taken by other data, default MfsConflictError
+func WithMfsConflict(conflict MfsConflict) UploadOption {
+	return newFuncUploadOption(func(o *uploadOption) {
+		o.MfsConflict = conflict
+	})
+}
+
+// WithPinMode sets whether the uploads are pinned recursively, copied to MFS or both, default PinBoth
+func WithPinMode(mode PinMode) UploadOption {
+	return newFuncUploadOption(func(o *uploadOption) {
+		o.PinMode = mode
+	})
+}
+
+// WithoutMfs keeps the uploads out of MFS, they are pinned recursively instead, it is WithPinMode(PinRecursive)
+func WithoutMfs() UploadOption {
+	return WithPinMode(PinRecursive)
+}
+
+// WithRemotePins replicates the uploads to the named MetaConf.PinningServices, all of them if no name is given
+func WithRemotePins(services ...string) UploadOption {
+	return newFuncUploadOption(func(o *uploadOption) {
+		o.RemotePin = true
+		o.RemotePinServices = services
+	})
+}
+
+// UploadOptions are the UnixFS parameters of `ipfs add`, which determine the cid of the data.
+// The zero value uses the ipfs defaults, and UploadOptions can be passed to Upload as an UploadOption.
+type UploadOptions struct {
+	CidVersion  *int   // 0 or 1, default Import.CidVersion of the ipfs node, which is 0 unless configured
+	RawLeaves   *bool  // use raw blocks for the leaves, default true for cid version 1
+	Chunker     string // size-<bytes>, rabin-<min>-<avg>-<max> or buzhash, default size-262144
+	Hash        string // multihash name, default sha2-256
+	Trickle     bool   // use the trickle layout instead of the balanced one
+	Inline      bool   // inline small blocks into the cids
+	InlineLimit int    // max block size to inline, default 32
+	OnlyHash    bool   // only compute the cid, the data is not stored in ipfs
+}
+
+func (o UploadOptions) apply(uo *uploadOption) {
+	uo.Add = o
+}
+
+func defaultUploadOptions() uploadOption {
+	return uploadOption{
+		Concurrency: 4,
+	}
+}
+
+// UploadResult is the result of a path uploaded by UploadMany
+type UploadResult struct {
+	Path     string
+	IpfsData *IpfsData
+	Err      error
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	shell "github.com/ipfs/go-ipfs-api"
+)
+
+// PinMode is how an upload is kept from the garbage collection of the ipfs node
+type PinMode int
+
+const (
+	PinBoth      PinMode = iota // pinned recursively and copied to MFS, like ipfs add and files cp do
+	PinMfs                      // only copied to MFS, not pinned
+	PinRecursive                // only pinned recursively, not copied to MFS
+)
+
+// ipfs pin types
+const (
+	PinTypeRecursive = "recursive"
+	PinTypeDirect    = "direct"
+	PinTypeIndirect  = "indirect"
+)
+
+// PinInfo is a pin of the ipfs node
+type PinInfo struct {
+	Cid  string
+	Type string // recursive or direct
+}
+
+// Pin pins the ipfsCid recursively on the ipfs node. Like the other pin requests it is sent to the first
+// available ipfs api in the order IpfsApi, IpfsApis, also with IpfsApiRoundRobin, and is not failed over,
+// so a pin is listed and removed on the node it is added to.
+func (m *MetaClient) Pin(ipfsCid string) error {
+	return m.PinContext(context.Background(), ipfsCid)
+}
+
+// PinContext is like Pin but binds the ipfs request to ctx
+func (m *MetaClient) PinContext(ctx context.Context, ipfsCid string) error {
+	node, err := m.primaryIpfsNode(ctx)
+	if err != nil {
+		return err
+	}
+	return pinRecursive(ctx, node.sh, ipfsCid)
+}
+
+// Unpin removes the recursive pin of the ipfsCid, the MFS entries referring to it are kept
+func (m *MetaClient) Unpin(ipfsCid string) error {
+	return m.UnpinContext(context.Background(), ipfsCid)
+}
+
+// UnpinContext is like Unpin but binds the ipfs request to ctx
+func (m *MetaClient) UnpinContext(ctx context.Context, ipfsCid string) error {
+	node, err := m.primaryIpfsNode(ctx)
+	if err != nil {
+		return err
+	}
+	return unpinRecursive(ctx, node.sh, ipfsCid)
+}
+
+// ListPins lists the recursive and direct pins of the ipfs node
+func (m *MetaClient) ListPins() ([]PinInfo, error) {
+	return m.ListPinsContext(context.Background())
+}
+
+// ListPinsContext is like ListPins but binds the ipfs requests to ctx
+func (m *MetaClient) ListPinsContext(ctx context.Context) ([]PinInfo, error) {
+	node, err := m.primaryIpfsNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pins []PinInfo
+	for _, pinType := range []string{PinTypeRecursive, PinTypeDirect} {
+		var out struct {
+			Keys map[string]struct {
+				Type string
+			}
+		}
+		if err = node.sh.Request("pin/ls").Option("type", pinType).Exec(ctx, &out); err != nil {
+			return nil, err
+		}
+		for key, info := range out.Keys {
+			pins = append(pins, PinInfo{Cid: key, Type: info.Type})
+		}
+	}
+	return pins, nil
+}
+
+// IsPinned reports whether the ipfsCid is pinned, directly or as a part of another pin
+func (m *MetaClient) IsPinned(ipfsCid string) (bool, error) {
+	return m.IsPinnedContext(context.Background(), ipfsCid)
+}
+
+// IsPinnedContext is like IsPinned but binds the ipfs request to ctx
+func (m *MetaClient) IsPinnedContext(ctx context.Context, ipfsCid string) (bool, error) {
+	node, err := m.primaryIpfsNode(ctx)
+	if err != nil {
+		return false, err
+	}
+	var out struct {
+		Keys map[string]struct {
+			Type string
+		}
+	}
+	err = node.sh.Request("pin/ls", ipfsCid).Option("type", "all").Exec(ctx, &out)
+	if err != nil {
+		var shellErr *shell.Error
+		if errors.As(err, &shellErr) && strings.Contains(shellErr.Message, "not pinned") {
+			return false, nil
+		}
+		return false, err
+	}
+	return len(out.Keys) > 0, nil
+}
+
+func pinRecursive(ctx context.Context, sh *shell.Shell, ipfsCid string) error {
+	return sh.Request("pin/add", ipfsCid).Option("recursive", true).Exec(ctx, nil)
+}
+
+// pinIfNot pins the ipfsCid recursively unless it is already, and reports whether the pin is added
+func pinIfNot(ctx context.Context, sh *shell.Shell, ipfsCid string) (bool, error) {
+	err := sh.Request("pin/ls", ipfsCid).Option("type", PinTypeRecursive).Exec(ctx, nil)
+	if err == nil {
+		return false, nil
+	}
+	var shellErr *shell.Error
+	if !errors.As(err, &shellErr) || !strings.Contains(shellErr.Message, "not pinned") {
+		return false, err
+	}
+	return true, pinRecursive(ctx, sh, ipfsCid)
+}
+
+func unpinRecursive(ctx context.Context, sh *shell.Shell, ipfsCid string) error {
+	return sh.Request("pin/rm", ipfsCid).Option("recursive", true).Exec(ctx, nil)
+}
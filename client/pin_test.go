@@ -0,0 +1,109 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// pinNode is a fake ipfs api keeping the recursive pins it is asked to add
+type pinNode struct {
+	*httptest.Server
+	mu   sync.Mutex
+	pins map[string]bool
+}
+
+func newPinNode(t *testing.T) *pinNode {
+	n := &pinNode{pins: map[string]bool{}}
+	n.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		arg := r.URL.Query().Get("arg")
+		switch r.URL.Path {
+		case "/api/v0/version":
+			json.NewEncoder(w).Encode(map[string]string{"Version": "0.0.0"})
+		case "/api/v0/pin/add":
+			n.pins[arg] = true
+			json.NewEncoder(w).Encode(map[string][]string{"Pins": {arg}})
+		case "/api/v0/pin/rm":
+			delete(n.pins, arg)
+			json.NewEncoder(w).Encode(map[string][]string{"Pins": {arg}})
+		case "/api/v0/pin/ls":
+			keys := map[string]map[string]string{}
+			for key := range n.pins {
+				if typ := r.URL.Query().Get("type"); (typ == PinTypeRecursive || typ == "all") && (arg == "" || arg == key) {
+					keys[key] = map[string]string{"Type": PinTypeRecursive}
+				}
+			}
+			if arg != "" && len(keys) == 0 {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]interface{}{"Message": "path '" + arg + "' is not pinned", "Code": 0, "Type": "error"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"Keys": keys})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(n.Close)
+	return n
+}
+
+func (n *pinNode) pinned() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.pins)
+}
+
+func TestPinsUseOneNode(t *testing.T) {
+	const ipfsCid = "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH"
+	tests := []struct {
+		name      string
+		firstDown bool
+	}{
+		{name: "first node"},
+		{name: "first node down", firstDown: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, second := newPinNode(t), newPinNode(t)
+			want := first
+			if tt.firstDown {
+				first.Close()
+				want = second
+			}
+			metaClient := NewClient("key", "token", &MetaConf{
+				IpfsApi:          first.URL,
+				IpfsApis:         []string{second.URL},
+				IpfsApiSelection: IpfsApiRoundRobin,
+			})
+
+			// with round robin the consecutive calls would start with different nodes
+			for i := 0; i < 3; i++ {
+				if err := metaClient.Pin(ipfsCid); err != nil {
+					t.Fatal(err)
+				}
+				pinned, err := metaClient.IsPinned(ipfsCid)
+				if err != nil || !pinned {
+					t.Fatalf("IsPinned = %v, %v", pinned, err)
+				}
+				pins, err := metaClient.ListPins()
+				if err != nil || len(pins) != 1 {
+					t.Fatalf("ListPins = %v, %v", pins, err)
+				}
+			}
+			if want.pinned() != 1 || first.pinned()+second.pinned() != 1 {
+				t.Errorf("pins on the nodes %d, %d", first.pinned(), second.pinned())
+			}
+
+			if err := metaClient.Unpin(ipfsCid); err != nil {
+				t.Fatal(err)
+			}
+			if pinned, err := metaClient.IsPinned(ipfsCid); err != nil || pinned {
+				t.Errorf("IsPinned after Unpin = %v, %v", pinned, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// UploadProgress is reported while Upload adds the data to ipfs
+type UploadProgress struct {
+	BytesSent      int64  // bytes of file content added to ipfs
+	TotalBytes     int64  // total bytes of file content to add
+	FilesProcessed int    // files added to ipfs
+	TotalFiles     int    // total files to add
+	CurrentFile    string // path of the file being added
+	Speed          int64  // bytes per second since the upload started
+}
+
+// uploadProgress tracks the progress of an upload from the `ipfs add` progress events,
+// which are named by the path relative to the parent of the uploaded directory
+type uploadProgress struct {
+	f       func(UploadProgress)
+	isDir   bool
+	files   map[string]bool // names of the regular files in the events
+	local   func(name string) string
+	start   time.Time
+	resumed int64 // bytes skipped by a resumed upload, excluded from the speed
+
+	progress     UploadProgress
+	completed    int64 // bytes of the files already added
+	current      string
+	currentBytes int64
+}
+
+// newTreeUploadProgress tracks the upload of the entries selected under the directory inputPath,
+// the files of a fs.FS are reported by the names in the events
+func newTreeUploadProgress(inputPath string, root *uploadEntry, f func(UploadProgress)) *uploadProgress {
+	p := &uploadProgress{f: f, isDir: true, files: map[string]bool{}, start: time.Now()}
+	parent := filepath.Dir(inputPath)
+	p.local = func(name string) string {
+		if root.fsys != nil {
+			return name
+		}
+		return filepath.Join(parent, filepath.FromSlash(name))
+	}
+
+	var count func(entry *uploadEntry, name string)
+	count = func(entry *uploadEntry, name string) {
+		switch {
+		case entry.mode.IsRegular():
+			p.files[name] = true
+			p.progress.TotalBytes += entry.size
+			p.progress.TotalFiles++
+		case entry.mode.IsDir():
+			for _, child := range entry.children {
+				count(child, path.Join(name, child.name))
+			}
+		}
+	}
+	count(root, root.name)
+	return p
+}
+
+// newFileUploadProgress tracks the upload of a single file, size is negative if it is unknown
+func newFileUploadProgress(name string, size int64, f func(UploadProgress)) *uploadProgress {
+	p := &uploadProgress{f: f, start: time.Now()}
+	p.local = func(string) string {
+		return name
+	}
+	p.progress = UploadProgress{TotalFiles: 1, CurrentFile: name}
+	if size > 0 {
+		p.progress.TotalBytes = size
+	}
+	return p
+}
+
+// bytes records that n bytes of the named file are added
+func (p *uploadProgress) bytes(name string, n int64) {
+	if name != p.current {
+		p.completed += p.currentBytes
+		p.current, p.currentBytes = name, 0
+		p.progress.CurrentFile = p.local(name)
+	}
+	p.currentBytes = n
+	p.report()
+}
+
+// added records that the named file or directory is added
+func (p *uploadProgress) added(name string) {
+	if name == p.current {
+		p.completed += p.currentBytes
+		p.current, p.currentBytes = "", 0
+	}
+	if !p.isDir || p.files[name] {
+		p.progress.FilesProcessed++
+		p.report()
+	}
+}
+
+// chunk records a chunk added by a resumable upload
+func (p *uploadProgress) chunk(n int64) {
+	p.completed += n
+	p.report()
+}
+
+// resume records the bytes added before a resumable upload was interrupted
+func (p *uploadProgress) resume(n int64) {
+	p.completed += n
+	p.resumed += n
+	p.report()
+}
+
+func (p *uploadProgress) report() {
+	if p == nil || p.f == nil {
+		return
+	}
+	p.progress.BytesSent = p.completed + p.currentBytes
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		p.progress.Speed = int64(float64(p.progress.BytesSent-p.resumed) / elapsed)
+	}
+	p.f(p.progress)
+}
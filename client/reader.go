@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// reader option
+type readerOption struct {
+	Offset int64
+	Length int64 // 0 reads to the end
+}
+
+type ReaderOption interface {
+	apply(*readerOption)
+}
+
+type funcReaderOption struct {
+	f func(*readerOption)
+}
+
+func (fro *funcReaderOption) apply(ro *readerOption) {
+	fro.f(ro)
+}
+
+func newFuncReaderOption(f func(*readerOption)) *funcReaderOption {
+	return &funcReaderOption{
+		f: f,
+	}
+}
+
+// WithRange reads length bytes of the file from offset, a length of 0 reads to the end of the file
+func WithRange(offset, length int64) ReaderOption {
+	return newFuncReaderOption(func(o *readerOption) {
+		o.Offset, o.Length = offset, length
+	})
+}
+
+// OpenReader opens the file at the slash separated subPath under ipfsCid for reading, an empty subPath opens the ipfsCid itself.
+// The sources of Download are tried in turn: the meta server links, MetaConf.IpfsGateway and MetaConf.PublicGateways,
+// and *DownloadError with the error of every source is returned if all of them fail. Nothing is written to the local disk.
+func (m *MetaClient) OpenReader(ipfsCid, subPath string, opts ...ReaderOption) (io.ReadCloser, error) {
+	return m.OpenReaderContext(context.Background(), ipfsCid, subPath, opts...)
+}
+
+// OpenReaderContext is like OpenReader but binds the requests to ctx, reading fails once ctx is done
+func (m *MetaClient) OpenReaderContext(ctx context.Context, ipfsCid, subPath string, opts ...ReaderOption) (io.ReadCloser, error) {
+	var opt readerOption
+	for _, o := range opts {
+		o.apply(&opt)
+	}
+	if opt.Offset < 0 || opt.Length < 0 {
+		return nil, fmt.Errorf("invalid range, offset: %d, length: %d", opt.Offset, opt.Length)
+	}
+
+	downInfo, err := m.DownloadFileInfoContext(ctx, ipfsCid)
+	if err != nil {
+		return nil, err
+	}
+	if len(downInfo) == 0 {
+		return nil, fmt.Errorf("%w: there are no available download links", ErrNotFound)
+	}
+
+	sources, failed := m.downloadSources(ipfsCid, downInfo, &downloadOption{})
+	entry, err := m.resolveSubPath(ctx, ipfsCid, subPath)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		sources, failed = withSubPath(sources, failed, entry)
+	}
+	if len(sources) > 0 && sources[0].isDir {
+		return nil, fmt.Errorf("%s is a directory, use Download to get it", PathJoin(ipfsCid, subPath))
+	}
+
+	for _, source := range sources {
+		r, err := openRange(ctx, http.DefaultClient, source.url, opt.Offset, opt.Length)
+		if err == nil {
+			return r, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Printf("open %s from %s failed: %v", ipfsCid, source.url, err)
+		failed = append(failed, &SourceError{Url: source.url, Err: err})
+	}
+	return nil, &DownloadError{IpfsCid: ipfsCid, Errors: failed}
+}
+
+// openRange requests the range of the url, a server which ignores the Range header
+// is read from the start, and the bytes before offset are skipped
+func openRange(ctx context.Context, client *http.Client, uri string, offset, length int64) (io.ReadCloser, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	ranged := offset > 0 || length > 0
+	if ranged {
+		byteRange := fmt.Sprintf("bytes=%d-", offset)
+		if length > 0 {
+			byteRange += strconv.FormatInt(offset+length-1, 10)
+		}
+		request.Header.Set("Range", byteRange)
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case response.StatusCode == http.StatusPartialContent && ranged:
+		if start := contentRangeStart(response.Header.Get("Content-Range")); start != offset {
+			response.Body.Close()
+			return nil, fmt.Errorf("requested range from %d, but got %q", offset, response.Header.Get("Content-Range"))
+		}
+	case response.StatusCode == http.StatusOK:
+		if offset > 0 {
+			if _, err = io.CopyN(io.Discard, response.Body, offset); err != nil {
+				response.Body.Close()
+				if err == io.EOF {
+					err = fmt.Errorf("offset %d is beyond the end of the file", offset)
+				}
+				return nil, err
+			}
+		}
+	default:
+		defer response.Body.Close()
+		return nil, newHttpStatusError(response, uri)
+	}
+
+	if length <= 0 {
+		return response.Body, nil
+	}
+	return &limitedReadCloser{Reader: io.LimitReader(response.Body, length), Closer: response.Body}, nil
+}
+
+// contentRangeStart parses the first byte position of Content-Range: bytes 100-199/1234
+func contentRangeStart(contentRange string) int64 {
+	if !strings.HasPrefix(contentRange, "bytes ") {
+		return -1
+	}
+	start, _, ok := strings.Cut(strings.TrimPrefix(contentRange, "bytes "), "-")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// limitedReadCloser reads the limited body and closes the response body
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
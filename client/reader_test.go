@@ -0,0 +1,65 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+)
+
+func TestContentRangeStart(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int64
+	}{
+		{"bytes 0-0/1234", 0},
+		{"bytes 100-199/1234", 100},
+		{"bytes 100-199/*", 100},
+		{"bytes */1234", -1},
+		{"", -1},
+	}
+	for _, tt := range tests {
+		if start := contentRangeStart(tt.header); start != tt.want {
+			t.Errorf("contentRangeStart(%q) = %d, want %d", tt.header, start, tt.want)
+		}
+	}
+}
+
+func TestOpenRange(t *testing.T) {
+	tests := []struct {
+		name           string
+		offset, length int64
+		wantErr        bool
+	}{
+		{name: "whole"},
+		{name: "offset", offset: 600},
+		{name: "range", offset: 100, length: 50},
+		{name: "length", length: 10},
+		{name: "beyond the end", offset: 2000, wantErr: true},
+	}
+	for _, ranges := range []bool{true, false} {
+		server := newRangeServer(t, ranges)
+		for _, tt := range tests {
+			r, err := openRange(context.Background(), server.Client(), server.URL, tt.offset, tt.length)
+			if tt.wantErr {
+				if err == nil {
+					r.Close()
+					t.Errorf("ranges %v, %s: no error", ranges, tt.name)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("ranges %v, %s: %v", ranges, tt.name, err)
+			}
+			got, err := io.ReadAll(r)
+			r.Close()
+			want := testContent[tt.offset:]
+			if tt.length > 0 {
+				want = want[:tt.length]
+			}
+			if err != nil || !bytes.Equal(got, want) {
+				t.Errorf("ranges %v, %s: read %d bytes, %v, want %d bytes", ranges, tt.name, len(got), err, len(want))
+			}
+		}
+	}
+}
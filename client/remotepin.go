@@ -0,0 +1,214 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	shell "github.com/ipfs/go-ipfs-api"
+)
+
+// PinningService is a remote pinning service speaking the IPFS Pinning Service API
+type PinningService struct {
+	Name        string // selects the service in WithRemotePins
+	Endpoint    string // e.g. https://api.pinata.cloud/psa
+	AccessToken string
+}
+
+// remote pin statuses of the IPFS Pinning Service API
+const (
+	RemotePinQueued  = "queued"
+	RemotePinPinning = "pinning"
+	RemotePinPinned  = "pinned"
+	RemotePinFailed  = "failed"
+)
+
+// defaultRemotePinInterval is the poll interval of WaitRemotePins
+const defaultRemotePinInterval = 5 * time.Second
+
+// RemotePin is the status of an upload pin request to a remote pinning service
+type RemotePin struct {
+	Service   string   // name of the pinning service
+	RequestId string   // empty if the pin request is not accepted
+	Status    string   // queued, pinning, pinned or failed
+	Info      string   // details from the service, or why the pin request failed
+	Delegates []string // multiaddrs of the service nodes fetching the data
+}
+
+// done reports whether the pin request will not change any more
+func (p *RemotePin) done() bool {
+	return p.Status == RemotePinPinned || p.Status == RemotePinFailed
+}
+
+// remotePinStatus is the PinStatus object of the IPFS Pinning Service API
+type remotePinStatus struct {
+	RequestId string            `json:"requestid"`
+	Status    string            `json:"status"`
+	Delegates []string          `json:"delegates"`
+	Info      map[string]string `json:"info"`
+}
+
+// UpdateRemotePins refreshes the status of the remote pin requests of the upload
+func (m *MetaClient) UpdateRemotePins(ipfsData *IpfsData) error {
+	return m.UpdateRemotePinsContext(context.Background(), ipfsData)
+}
+
+// UpdateRemotePinsContext is like UpdateRemotePins but binds the pinning service requests to ctx
+func (m *MetaClient) UpdateRemotePinsContext(ctx context.Context, ipfsData *IpfsData) error {
+	for i := range ipfsData.RemotePins {
+		pin := &ipfsData.RemotePins[i]
+		if pin.RequestId == "" || pin.done() {
+			continue
+		}
+		services, err := m.pinningServices([]string{pin.Service})
+		if err != nil {
+			return err
+		}
+		var status remotePinStatus
+		err = remotePinRequest(ctx, services[0], http.MethodGet, url.PathEscape(pin.RequestId), nil, &status)
+		if err != nil {
+			return err
+		}
+		pin.Status, pin.Info = status.Status, formatPinInfo(status.Info)
+	}
+	return nil
+}
+
+// WaitRemotePins updates the remote pin requests of the upload until they are pinned or failed
+func (m *MetaClient) WaitRemotePins(ipfsData *IpfsData, interval time.Duration) error {
+	return m.WaitRemotePinsContext(context.Background(), ipfsData, interval)
+}
+
+// WaitRemotePinsContext is like WaitRemotePins but stops waiting when ctx is done
+func (m *MetaClient) WaitRemotePinsContext(ctx context.Context, ipfsData *IpfsData, interval time.Duration) error {
+	if interval <= 0 {
+		interval = defaultRemotePinInterval
+	}
+	for {
+		if err := m.UpdateRemotePinsContext(ctx, ipfsData); err != nil {
+			return err
+		}
+		done := true
+		for _, pin := range ipfsData.RemotePins {
+			done = done && pin.done()
+		}
+		if done {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// pinRemote requests the WithRemotePins services to pin the upload, the ipfs node is passed as the origin.
+// A service which does not accept the request is recorded as failed, the upload itself is kept.
+func (m *MetaClient) pinRemote(ctx context.Context, sh *shell.Shell, ipfsData *IpfsData, opt *uploadOption) error {
+	if !opt.RemotePin || opt.Add.OnlyHash {
+		return nil
+	}
+	services, err := m.pinningServices(opt.RemotePinServices)
+	if err != nil {
+		return err
+	}
+
+	// the origins only speed up the transfer, the services find the data without them too
+	var id struct {
+		Addresses []string
+	}
+	_ = sh.Request("id").Exec(ctx, &id)
+
+	request := map[string]interface{}{
+		"cid":  ipfsData.IpfsCid,
+		"name": filepath.Base(ipfsData.SourceName),
+	}
+	if len(id.Addresses) > 0 {
+		request["origins"] = id.Addresses
+	}
+	for _, service := range services {
+		pin := RemotePin{Service: service.Name}
+		var status remotePinStatus
+		if err = remotePinRequest(ctx, service, http.MethodPost, "", request, &status); err != nil {
+			pin.Status, pin.Info = RemotePinFailed, err.Error()
+		} else {
+			pin.RequestId, pin.Status, pin.Delegates = status.RequestId, status.Status, status.Delegates
+			pin.Info = formatPinInfo(status.Info)
+			if len(status.Delegates) > 0 {
+				// connecting to the delegates lets the service fetch the data directly
+				_ = sh.SwarmConnect(ctx, status.Delegates...)
+			}
+		}
+		ipfsData.RemotePins = append(ipfsData.RemotePins, pin)
+	}
+	return nil
+}
+
+// pinningServices returns the configured pinning services with the names, all of them if no name is given
+func (m *MetaClient) pinningServices(names []string) ([]PinningService, error) {
+	if m.conf == nil || len(m.conf.PinningServices) == 0 {
+		return nil, fmt.Errorf("no pinning service is configured")
+	}
+	if len(names) == 0 {
+		return m.conf.PinningServices, nil
+	}
+	var services []PinningService
+	for _, name := range names {
+		found := false
+		for _, service := range m.conf.PinningServices {
+			if service.Name == name {
+				services = append(services, service)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("pinning service %s is not configured", name)
+		}
+	}
+	return services, nil
+}
+
+// remotePinRequest sends the request to the pins endpoint of the service, requestId is empty to add a pin
+func remotePinRequest(ctx context.Context, service PinningService, method, requestId string, params, out interface{}) error {
+	uri := PathJoin(service.Endpoint, "pins", requestId)
+	var body bytes.Buffer
+	if params != nil {
+		if err := json.NewEncoder(&body).Encode(params); err != nil {
+			return err
+		}
+	}
+	request, err := http.NewRequestWithContext(ctx, method, uri, &body)
+	if err != nil {
+		return err
+	}
+	if params != nil {
+		request.Header.Set("Content-Type", contentTypeJson)
+	}
+	request.Header.Set("Authorization", "Bearer "+service.AccessToken)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusAccepted {
+		return newHttpStatusError(response, uri)
+	}
+	return json.NewDecoder(response.Body).Decode(out)
+}
+
+// formatPinInfo joins the info of a pin status into a string
+func formatPinInfo(info map[string]string) string {
+	if len(info) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(info)
+	return string(data)
+}
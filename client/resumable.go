@@ -0,0 +1,325 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	shell "github.com/ipfs/go-ipfs-api"
+	files "github.com/ipfs/go-ipfs-files"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// journalSuffix is appended to the file name for the journal of a resumable upload
+const journalSuffix = ".ipfs-upload"
+
+const (
+	// journalSyncChunks is the count of chunks the journal is synced after, the chunks which
+	// are not synced when the upload is interrupted are added again
+	journalSyncChunks = 64
+	// journalSyncInterval is how long the journal is synced after, if fewer chunks are added
+	journalSyncInterval = time.Second
+)
+
+// uploadJournalHeader is the first line of the journal, the journal is discarded if the file
+// or the parameters changed since it was written
+type uploadJournalHeader struct {
+	Size        int64  `json:"size"`
+	ModTime     int64  `json:"mod_time"`
+	ChunkSize   int64  `json:"chunk_size"`
+	CidVersion  int    `json:"cid_version"`
+	RawLeaves   bool   `json:"raw_leaves"`
+	HashFunc    uint64 `json:"hash_func"`
+	Inline      bool   `json:"inline"`
+	InlineLimit int    `json:"inline_limit"`
+	IpfsApi     string `json:"ipfs_api"` // the chunks are added to, another node does not have them
+}
+
+// uploadJournalLeaf is written to the journal after the chunk is added to ipfs
+type uploadJournalLeaf struct {
+	Cid      string `json:"cid"`
+	FileSize uint64 `json:"file_size"`
+	Tsize    uint64 `json:"tsize"`
+}
+
+// uploadJournal records the added chunks of a resumable upload, one json object per line
+type uploadJournal struct {
+	file     *os.File
+	unsynced int       // chunks written since the last sync
+	synced   time.Time // of the last sync
+}
+
+// openUploadJournal opens the journal of the file and returns the chunks added before,
+// a journal of another file version or other parameters is started over
+func openUploadJournal(journalPath string, header uploadJournalHeader) (*uploadJournal, []dagNode, error) {
+	file, err := os.OpenFile(journalPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	leaves, good := readUploadJournal(file, header)
+	if good == 0 {
+		line, err := json.Marshal(header)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		line = append(line, '\n')
+		good = int64(len(line))
+		if _, err = file.WriteAt(line, 0); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+	}
+	// drop a partly written last line
+	if err = file.Truncate(good); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if _, err = file.Seek(good, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return &uploadJournal{file: file, synced: time.Now()}, leaves, nil
+}
+
+// readUploadJournal returns the recorded chunks and the length of the valid part of the journal,
+// which is 0 if the journal does not match the header
+func readUploadJournal(r io.Reader, header uploadJournalHeader) ([]dagNode, int64) {
+	reader := bufio.NewReader(r)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, 0
+	}
+	var recorded uploadJournalHeader
+	if json.Unmarshal(line, &recorded) != nil || recorded != header {
+		return nil, 0
+	}
+
+	good := int64(len(line))
+	var leaves []dagNode
+	for {
+		line, err = reader.ReadBytes('\n')
+		if err != nil {
+			return leaves, good
+		}
+		var leaf uploadJournalLeaf
+		if json.Unmarshal(line, &leaf) != nil {
+			return leaves, good
+		}
+		c, err := cid.Decode(leaf.Cid)
+		if err != nil {
+			return leaves, good
+		}
+		leaves = append(leaves, dagNode{Cid: c, FileSize: leaf.FileSize, Tsize: leaf.Tsize})
+		good += int64(len(line))
+	}
+}
+
+func (j *uploadJournal) add(leaf dagNode) error {
+	line, err := json.Marshal(uploadJournalLeaf{Cid: leaf.Cid.String(), FileSize: leaf.FileSize, Tsize: leaf.Tsize})
+	if err != nil {
+		return err
+	}
+	if _, err = j.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	j.unsynced++
+	if j.unsynced < journalSyncChunks && time.Since(j.synced) < journalSyncInterval {
+		return nil
+	}
+	return j.sync()
+}
+
+func (j *uploadJournal) sync() error {
+	j.unsynced, j.synced = 0, time.Now()
+	return j.file.Sync()
+}
+
+// Close syncs the chunks written since the last sync and closes the journal
+func (j *uploadJournal) Close() error {
+	var err error
+	if j.unsynced > 0 {
+		err = j.sync()
+	}
+	if closeErr := j.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// uploadResumable adds the file chunk by chunk and returns the root cid, the chunks recorded
+// in the journal by an interrupted upload are skipped. The journal is kept until removeUploadJournal.
+// The blocks are not pinned until the root is placed, so the recorded chunks which the garbage
+// collection of ipfs removed in the meantime are added again.
+func uploadResumable(ctx context.Context, sh *shell.Shell, ipfsApi string, fileName string, params unixfsParams, progress *uploadProgress) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	b := newDagBuilder(params)
+	b.put = func(c cid.Cid, block []byte) error {
+		return putBlock(ctx, sh, c, block)
+	}
+
+	journalPath := fileName + journalSuffix
+	journal, leaves, err := openUploadJournal(journalPath, uploadJournalHeader{
+		Size:        stat.Size(),
+		ModTime:     stat.ModTime().UnixNano(),
+		ChunkSize:   b.params.ChunkSize,
+		CidVersion:  b.params.CidVersion,
+		RawLeaves:   b.params.RawLeaves,
+		HashFunc:    b.params.HashFunc,
+		Inline:      b.params.Inline,
+		InlineLimit: b.params.InlineLimit,
+		IpfsApi:     ipfsApi,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer journal.Close()
+
+	if err = restoreMissingLeaves(ctx, sh, b, file, leaves); err != nil {
+		return "", err
+	}
+	var offset int64
+	for _, leaf := range leaves {
+		offset += int64(leaf.FileSize)
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return "", err
+	}
+	if progress != nil && offset > 0 {
+		progress.resume(offset)
+	}
+
+	buf := make([]byte, b.params.ChunkSize)
+	root, err := b.layout(&leafSource{next: func() (dagNode, error) {
+		if len(leaves) > 0 {
+			leaf := leaves[0]
+			leaves = leaves[1:]
+			return leaf, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return dagNode{}, err
+		}
+
+		n, err := io.ReadFull(file, buf)
+		switch {
+		case n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF):
+			return dagNode{}, io.EOF
+		case err != nil && err != io.ErrUnexpectedEOF:
+			return dagNode{}, err
+		}
+		leaf, err := b.leaf(buf[:n])
+		if err != nil {
+			return dagNode{}, err
+		}
+		if err = journal.add(leaf); err != nil {
+			return dagNode{}, err
+		}
+		if progress != nil {
+			progress.chunk(int64(n))
+		}
+		return leaf, nil
+	}})
+	if err != nil {
+		return "", err
+	}
+
+	if progress != nil {
+		progress.added(fileName)
+	}
+	return root.Cid.String(), nil
+}
+
+// restoreMissingLeaves adds the recorded chunks again which ipfs does not have any more,
+// they are read from the file at their offsets
+func restoreMissingLeaves(ctx context.Context, sh *shell.Shell, b *dagBuilder, file *os.File, leaves []dagNode) error {
+	var offset int64
+	for _, leaf := range leaves {
+		chunkOffset := offset
+		offset += int64(leaf.FileSize)
+		if leaf.Cid.Prefix().MhType == mh.IDENTITY {
+			continue
+		}
+		err := sh.Request("block/stat", leaf.Cid.String()).Option("offline", true).Exec(ctx, nil)
+		if err == nil {
+			continue
+		}
+		// an error from ipfs means the block is not found locally
+		var shellErr *shell.Error
+		if !errors.As(err, &shellErr) {
+			return err
+		}
+
+		data := make([]byte, leaf.FileSize)
+		if _, err = file.ReadAt(data, chunkOffset); err != nil {
+			return err
+		}
+		restored, err := b.leaf(data)
+		if err != nil {
+			return err
+		}
+		if !restored.Cid.Equals(leaf.Cid) {
+			return fmt.Errorf("chunk at %d of %s changed, remove %s to upload it again", chunkOffset, file.Name(), file.Name()+journalSuffix)
+		}
+	}
+	return nil
+}
+
+// removeUploadJournal removes the journal once the upload is placed in ipfs
+func removeUploadJournal(fileName string) {
+	os.Remove(fileName + journalSuffix)
+}
+
+// putBlock adds the block to ipfs like shell.BlockPut does, but binds the request to ctx
+// and checks that ipfs stored it under the expected cid
+func putBlock(ctx context.Context, sh *shell.Shell, c cid.Cid, block []byte) error {
+	prefix := c.Prefix()
+	if prefix.MhType == mh.IDENTITY {
+		// inlined into the cid, nothing to store
+		return nil
+	}
+
+	var format string
+	switch {
+	case prefix.Codec == cid.Raw:
+		format = "raw"
+	case prefix.Version == 0:
+		format = "v0"
+	default:
+		format = "protobuf"
+	}
+
+	slf := files.NewSliceDirectory([]files.DirEntry{files.FileEntry("", files.NewReaderFile(bytes.NewReader(block)))})
+	var out struct {
+		Key string
+	}
+	err := sh.Request("block/put").
+		Option("format", format).
+		Option("mhtype", mh.Codes[prefix.MhType]).
+		Option("mhlen", prefix.MhLength).
+		Body(files.NewMultiFileReader(slf, true)).
+		Exec(ctx, &out)
+	if err != nil {
+		return err
+	}
+	if out.Key != c.String() {
+		return fmt.Errorf("ipfs stored the block %s as %s", c, out.Key)
+	}
+	return nil
+}
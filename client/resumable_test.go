@@ -0,0 +1,54 @@
+package client
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestUploadJournal(t *testing.T) {
+	header := uploadJournalHeader{Size: 1000, ChunkSize: 4, Inline: true, InlineLimit: defaultInlineLimit, IpfsApi: "http://127.0.0.1:5001"}
+	leaf := dagNode{Cid: cid.MustParse("QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH"), FileSize: 4, Tsize: 4}
+	inlineLimit := header
+	inlineLimit.InlineLimit = 64
+
+	tests := []struct {
+		name       string
+		header     uploadJournalHeader
+		wantLeaves int
+	}{
+		{name: "same header", header: header, wantLeaves: journalSyncChunks + 1},
+		{name: "other inline limit", header: inlineLimit, wantLeaves: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			journalPath := filepath.Join(t.TempDir(), "file"+journalSuffix)
+			journal, _, err := openUploadJournal(journalPath, header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < journalSyncChunks+1; i++ {
+				if err = journal.add(leaf); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// synced after journalSyncChunks at the latest, the rest is synced by Close
+			if journal.unsynced > 1 {
+				t.Errorf("%d chunks are not synced, want at most 1", journal.unsynced)
+			}
+			if err = journal.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			journal, leaves, err := openUploadJournal(journalPath, tt.header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer journal.Close()
+			if len(leaves) != tt.wantLeaves {
+				t.Errorf("%d chunks are recorded, want %d", len(leaves), tt.wantLeaves)
+			}
+		})
+	}
+}
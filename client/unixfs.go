@@ -0,0 +1,703 @@
+package client
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// This file builds UnixFS DAGs locally the same way `ipfs add` does with the balanced layout
+// and the size-N chunker, so the cid of the data can be computed without an ipfs node.
+
+// unixfs data types
+const (
+	unixfsRaw       = 0
+	unixfsDirectory = 1
+	unixfsFile      = 2
+	unixfsMetadata  = 3
+	unixfsSymlink   = 4
+	unixfsHAMTShard = 5
+)
+
+const (
+	defaultChunkSize   = 256 << 10
+	defaultMaxLinks    = 174
+	defaultInlineLimit = 32
+	// directories of this estimated size or over are sharded by ipfs, which is not supported locally
+	shardingThreshold = 256 << 10
+)
+
+var ErrShardedDirectory = errors.New("directory is too large and would be sharded by ipfs, which is not supported")
+
+// unixfsParams are the parameters which determine the cid of the added data
+type unixfsParams struct {
+	CidVersion  int
+	RawLeaves   bool
+	ChunkSize   int64
+	HashFunc    uint64 // multihash code
+	Inline      bool
+	InlineLimit int
+	MaxLinks    int
+}
+
+func defaultUnixfsParams() unixfsParams {
+	return unixfsParams{
+		CidVersion:  0,
+		ChunkSize:   defaultChunkSize,
+		HashFunc:    mh.SHA2_256,
+		InlineLimit: defaultInlineLimit,
+		MaxLinks:    defaultMaxLinks,
+	}
+}
+
+// unixfsParamsFor converts the upload options to the parameters of the local builder,
+// which only supports the size-N chunker and the balanced layout
+func unixfsParamsFor(o UploadOptions) (unixfsParams, error) {
+	params := defaultUnixfsParams()
+	if o.CidVersion != nil {
+		switch *o.CidVersion {
+		case 0, 1:
+			params.CidVersion = *o.CidVersion
+		default:
+			return params, fmt.Errorf("unknown cid version %d", *o.CidVersion)
+		}
+	}
+
+	switch {
+	case o.Chunker == "" || o.Chunker == "default":
+	case strings.HasPrefix(o.Chunker, "size-"):
+		size, err := strconv.ParseInt(strings.TrimPrefix(o.Chunker, "size-"), 10, 64)
+		// ipfs limits the chunks to 1MiB
+		if err != nil || size <= 0 || size > 1<<20 {
+			return params, fmt.Errorf("invalid chunker %s", o.Chunker)
+		}
+		params.ChunkSize = size
+	default:
+		return params, fmt.Errorf("chunker %s is not supported locally, only size-<bytes> is", o.Chunker)
+	}
+
+	if o.Hash != "" {
+		code, ok := mh.Names[o.Hash]
+		if !ok {
+			return params, fmt.Errorf("unknown hash function %s", o.Hash)
+		}
+		params.HashFunc = code
+	}
+	// like ipfs add, another hash function than sha2-256 upgrades to cid v1 before the raw leaves default
+	if params.HashFunc != mh.SHA2_256 {
+		if o.CidVersion != nil && *o.CidVersion == 0 {
+			return params, errors.New("cid version 0 only supports sha2-256")
+		}
+		params.CidVersion = 1
+	}
+	params.RawLeaves = params.CidVersion == 1
+	if o.RawLeaves != nil {
+		params.RawLeaves = *o.RawLeaves
+	}
+	if o.Trickle {
+		return params, errors.New("trickle layout is not supported locally")
+	}
+	params.Inline = o.Inline
+	if o.InlineLimit > 0 {
+		params.InlineLimit = o.InlineLimit
+	}
+	return params, nil
+}
+
+// unixfsParamsOf guesses the parameters from the root cid, assuming the ipfs add defaults otherwise
+func unixfsParamsOf(root cid.Cid) unixfsParams {
+	params := defaultUnixfsParams()
+	prefix := root.Prefix()
+	if prefix.Version == 1 {
+		params.CidVersion = 1
+		params.RawLeaves = true
+	}
+	if prefix.MhType != mh.IDENTITY {
+		params.HashFunc = prefix.MhType
+	}
+	return params
+}
+
+// dagNode is the root of a built DAG
+type dagNode struct {
+	Cid      cid.Cid
+	FileSize uint64 // size of the file content
+	Tsize    uint64 // cumulative size of all the blocks
+}
+
+// dagLink is a named directory entry
+type dagLink struct {
+	Name string
+	Node dagNode
+}
+
+type dagBuilder struct {
+	params unixfsParams
+	// put is called with every block built, if it is not nil
+	put func(c cid.Cid, block []byte) error
+}
+
+func newDagBuilder(params unixfsParams) *dagBuilder {
+	if params.ChunkSize <= 0 {
+		params.ChunkSize = defaultChunkSize
+	}
+	if params.MaxLinks <= 0 {
+		params.MaxLinks = defaultMaxLinks
+	}
+	if params.InlineLimit <= 0 {
+		params.InlineLimit = defaultInlineLimit
+	}
+	if params.HashFunc != mh.SHA2_256 {
+		// cid v0 only supports sha2-256
+		params.CidVersion = 1
+	}
+	return &dagBuilder{params: params}
+}
+
+// file chunks r and builds the file DAG with the balanced layout
+func (b *dagBuilder) file(r io.Reader) (dagNode, error) {
+	buf := make([]byte, b.params.ChunkSize)
+	return b.layout(&leafSource{next: func() (dagNode, error) {
+		n, err := io.ReadFull(r, buf)
+		switch {
+		case n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF):
+			return dagNode{}, io.EOF
+		case err != nil && err != io.ErrUnexpectedEOF:
+			return dagNode{}, err
+		}
+		return b.leaf(buf[:n])
+	}})
+}
+
+// layout builds the balanced DAG over the leaves of src
+func (b *dagBuilder) layout(src *leafSource) (dagNode, error) {
+	if src.done() {
+		if src.err != nil {
+			return dagNode{}, src.err
+		}
+		// empty file
+		return b.leaf(nil)
+	}
+
+	root := src.pop()
+	for depth := 1; !src.done(); depth++ {
+		var err error
+		if root, err = b.fill(src, []dagNode{root}, depth); err != nil {
+			return dagNode{}, err
+		}
+	}
+	if src.err != nil {
+		return dagNode{}, src.err
+	}
+	return root, nil
+}
+
+// fill adds children to the node until it is full or src is drained
+func (b *dagBuilder) fill(src *leafSource, children []dagNode, depth int) (dagNode, error) {
+	for len(children) < b.params.MaxLinks && !src.done() {
+		if depth == 1 {
+			children = append(children, src.pop())
+			continue
+		}
+		child, err := b.fill(src, nil, depth-1)
+		if err != nil {
+			return dagNode{}, err
+		}
+		children = append(children, child)
+	}
+	if src.err != nil {
+		return dagNode{}, src.err
+	}
+	return b.fileNode(children)
+}
+
+// leaf builds the leaf block of a chunk
+func (b *dagBuilder) leaf(data []byte) (dagNode, error) {
+	if b.params.RawLeaves {
+		c, err := b.store(cid.Raw, data)
+		if err != nil {
+			return dagNode{}, err
+		}
+		return dagNode{Cid: c, FileSize: uint64(len(data)), Tsize: uint64(len(data))}, nil
+	}
+
+	size := uint64(len(data))
+	return b.pbNode(nil, encodeUnixfs(unixfsFile, data, &size, nil), size)
+}
+
+// fileNode builds the internal node of a file DAG
+func (b *dagBuilder) fileNode(children []dagNode) (dagNode, error) {
+	var fileSize uint64
+	links := make([]dagLink, 0, len(children))
+	blockSizes := make([]uint64, 0, len(children))
+	for _, child := range children {
+		fileSize += child.FileSize
+		links = append(links, dagLink{Node: child})
+		blockSizes = append(blockSizes, child.FileSize)
+	}
+	return b.pbNode(links, encodeUnixfs(unixfsFile, nil, &fileSize, blockSizes), fileSize)
+}
+
+// directory builds the directory node over the entries
+func (b *dagBuilder) directory(entries []dagLink) (dagNode, error) {
+	var estimated int
+	for _, entry := range entries {
+		estimated += len(entry.Name) + entry.Node.Cid.ByteLen()
+	}
+	if estimated >= shardingThreshold {
+		return dagNode{}, ErrShardedDirectory
+	}
+
+	links := make([]dagLink, len(entries))
+	copy(links, entries)
+	sort.SliceStable(links, func(i, j int) bool {
+		return links[i].Name < links[j].Name
+	})
+
+	var fileSize uint64
+	for _, link := range links {
+		fileSize += link.Node.FileSize
+	}
+	node, err := b.pbNode(links, encodeUnixfs(unixfsDirectory, nil, nil, nil), 0)
+	node.FileSize = fileSize
+	return node, err
+}
+
+// symlink builds the symlink node to target
+func (b *dagBuilder) symlink(target string) (dagNode, error) {
+	return b.pbNode(nil, encodeUnixfs(unixfsSymlink, []byte(target), nil, nil), 0)
+}
+
+func (b *dagBuilder) pbNode(links []dagLink, data []byte, fileSize uint64) (dagNode, error) {
+	block := encodePBNode(links, data)
+	c, err := b.store(cid.DagProtobuf, block)
+	if err != nil {
+		return dagNode{}, err
+	}
+	tsize := uint64(len(block))
+	for _, link := range links {
+		tsize += link.Node.Tsize
+	}
+	return dagNode{Cid: c, FileSize: fileSize, Tsize: tsize}, nil
+}
+
+// store computes the cid of the block and hands it to put
+func (b *dagBuilder) store(codec uint64, block []byte) (cid.Cid, error) {
+	c, err := b.cidOf(codec, block)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if b.put != nil {
+		if err = b.put(c, block); err != nil {
+			return cid.Undef, err
+		}
+	}
+	return c, nil
+}
+
+func (b *dagBuilder) cidOf(codec uint64, block []byte) (cid.Cid, error) {
+	if b.params.Inline && len(block) <= b.params.InlineLimit {
+		hash, err := mh.Sum(block, mh.IDENTITY, -1)
+		if err != nil {
+			return cid.Undef, err
+		}
+		return cid.NewCidV1(codec, hash), nil
+	}
+
+	prefix := cid.Prefix{
+		Version:  uint64(b.params.CidVersion),
+		Codec:    codec,
+		MhType:   b.params.HashFunc,
+		MhLength: -1,
+	}
+	if codec == cid.Raw {
+		prefix.Version = 1
+	}
+	return prefix.Sum(block)
+}
+
+// addPath builds the DAG of the file or directory like `ipfs add -r`, hidden files are skipped
+func (b *dagBuilder) addPath(filePath string) (dagNode, error) {
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return dagNode{}, err
+	}
+	return b.addEntry(filePath, stat)
+}
+
+func (b *dagBuilder) addEntry(filePath string, stat os.FileInfo) (dagNode, error) {
+	switch mode := stat.Mode(); {
+	case mode.IsRegular():
+		file, err := os.Open(filePath)
+		if err != nil {
+			return dagNode{}, err
+		}
+		defer file.Close()
+		return b.file(file)
+	case mode.IsDir():
+		entries, err := os.ReadDir(filePath)
+		if err != nil {
+			return dagNode{}, err
+		}
+		links := make([]dagLink, 0, len(entries))
+		for _, entry := range entries {
+			if isHidden(entry.Name()) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return dagNode{}, err
+			}
+			node, err := b.addEntry(filepath.Join(filePath, entry.Name()), info)
+			if err != nil {
+				return dagNode{}, err
+			}
+			links = append(links, dagLink{Name: entry.Name(), Node: node})
+		}
+		return b.directory(links)
+	case mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(filePath)
+		if err != nil {
+			return dagNode{}, err
+		}
+		return b.symlink(target)
+	default:
+		return dagNode{}, fmt.Errorf("unrecognized file type for %s: %s", filePath, mode.String())
+	}
+}
+
+// addTree builds the DAG of the entries selected by uploadFilter
+func (b *dagBuilder) addTree(entry *uploadEntry) (dagNode, error) {
+	switch {
+	case entry.mode.IsDir():
+		links := make([]dagLink, 0, len(entry.children))
+		for _, child := range entry.children {
+			node, err := b.addTree(child)
+			if err != nil {
+				return dagNode{}, err
+			}
+			links = append(links, dagLink{Name: child.name, Node: node})
+		}
+		return b.directory(links)
+	case entry.mode&os.ModeSymlink != 0:
+		return b.symlink(entry.target)
+	default:
+		file, err := os.Open(entry.path)
+		if err != nil {
+			return dagNode{}, err
+		}
+		defer file.Close()
+		return b.file(file)
+	}
+}
+
+// addTar builds the DAG of the single top level file or directory in the tar stream,
+// the way it was added before the gateway packed it with ?format=tar
+func (b *dagBuilder) addTar(r io.Reader) (dagNode, error) {
+	tr := tar.NewReader(r)
+	children := make(map[string][]dagLink)
+	dirs := make(map[string]bool)
+	nodes := make(map[string]dagNode)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return dagNode{}, err
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if name == "." || name == ".." || strings.HasPrefix(name, "../") {
+			return dagNode{}, fmt.Errorf("invalid tar entry %q", hdr.Name)
+		}
+
+		var node dagNode
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			dirs[name] = true
+			continue
+		case tar.TypeReg, tar.TypeRegA:
+			node, err = b.file(tr)
+		case tar.TypeSymlink:
+			node, err = b.symlink(hdr.Linkname)
+		default:
+			return dagNode{}, fmt.Errorf("unsupported tar entry %q of type %c", hdr.Name, hdr.Typeflag)
+		}
+		if err != nil {
+			return dagNode{}, err
+		}
+		nodes[name] = node
+		parent := path.Dir(name)
+		children[parent] = append(children[parent], dagLink{Name: path.Base(name), Node: node})
+	}
+
+	// build directories bottom-up, the parents of entries are directories even without a tar header
+	for name := range nodes {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return strings.Count(sorted[i], "/") > strings.Count(sorted[j], "/")
+	})
+	for _, dir := range sorted {
+		node, err := b.directory(children[dir])
+		if err != nil {
+			return dagNode{}, err
+		}
+		nodes[dir] = node
+		parent := path.Dir(dir)
+		children[parent] = append(children[parent], dagLink{Name: path.Base(dir), Node: node})
+	}
+
+	roots := children["."]
+	if len(roots) != 1 {
+		return dagNode{}, fmt.Errorf("tar should contain exactly one top level entry, found %d", len(roots))
+	}
+	return roots[0].Node, nil
+}
+
+// isHidden reports whether the file is hidden, ipfs skips hidden files when adding a directory
+func isHidden(name string) bool {
+	switch name {
+	case "", ".", "..":
+		return false
+	default:
+		return name[0] == '.'
+	}
+}
+
+// leafSource yields the leaves of a file, done peeks the next one
+type leafSource struct {
+	next   func() (dagNode, error)
+	peeked *dagNode
+	eof    bool
+	err    error
+}
+
+func (s *leafSource) done() bool {
+	if s.peeked != nil {
+		return false
+	}
+	if s.eof || s.err != nil {
+		return true
+	}
+	leaf, err := s.next()
+	switch {
+	case err == io.EOF:
+		s.eof = true
+		return true
+	case err != nil:
+		s.err = err
+		return true
+	}
+	s.peeked = &leaf
+	return false
+}
+
+func (s *leafSource) pop() dagNode {
+	leaf := *s.peeked
+	s.peeked = nil
+	return leaf
+}
+
+// protobuf encoding of the dag-pb PBNode and the unixfs Data messages
+
+func encodeUnixfs(typ int, data []byte, fileSize *uint64, blockSizes []uint64) []byte {
+	buf := appendVarintField(nil, 1, uint64(typ))
+	if data != nil {
+		buf = appendBytesField(buf, 2, data)
+	}
+	if fileSize != nil {
+		buf = appendVarintField(buf, 3, *fileSize)
+	}
+	for _, size := range blockSizes {
+		buf = appendVarintField(buf, 4, size)
+	}
+	return buf
+}
+
+// encodePBNode encodes the node in the canonical dag-pb form, links come before data
+func encodePBNode(links []dagLink, data []byte) []byte {
+	var buf []byte
+	for _, link := range links {
+		var lb []byte
+		lb = appendBytesField(lb, 1, link.Node.Cid.Bytes())
+		lb = appendBytesField(lb, 2, []byte(link.Name))
+		lb = appendVarintField(lb, 3, link.Node.Tsize)
+		buf = appendBytesField(buf, 2, lb)
+	}
+	if data != nil {
+		buf = appendBytesField(buf, 1, data)
+	}
+	return buf
+}
+
+func appendVarintField(buf []byte, field int, v uint64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field)<<3)
+	return binary.AppendUvarint(buf, v)
+}
+
+func appendBytesField(buf []byte, field int, data []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field)<<3|2)
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+// protobuf decoding of the dag-pb PBNode and the unixfs Data messages
+
+type pbLink struct {
+	Hash  cid.Cid
+	Name  string
+	Tsize uint64
+}
+
+type pbNode struct {
+	Links []pbLink
+	Data  []byte
+}
+
+type unixfsData struct {
+	Type       int
+	Data       []byte
+	FileSize   uint64
+	BlockSizes []uint64
+}
+
+func decodePBNode(block []byte) (*pbNode, error) {
+	var node pbNode
+	err := decodeFields(block, func(field int, v uint64, data []byte) error {
+		switch field {
+		case 1:
+			node.Data = data
+		case 2:
+			var link pbLink
+			err := decodeFields(data, func(field int, v uint64, data []byte) error {
+				switch field {
+				case 1:
+					c, err := cid.Cast(data)
+					if err != nil {
+						return err
+					}
+					link.Hash = c
+				case 2:
+					link.Name = string(data)
+				case 3:
+					link.Tsize = v
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if !link.Hash.Defined() {
+				return errors.New("dag-pb link without hash")
+			}
+			node.Links = append(node.Links, link)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+func decodeUnixfs(data []byte) (*unixfsData, error) {
+	var fs unixfsData
+	err := decodeFields(data, func(field int, v uint64, data []byte) error {
+		switch field {
+		case 1:
+			fs.Type = int(v)
+		case 2:
+			fs.Data = data
+		case 3:
+			fs.FileSize = v
+		case 4:
+			if data == nil {
+				fs.BlockSizes = append(fs.BlockSizes, v)
+				return nil
+			}
+			// packed encoding
+			for len(data) > 0 {
+				size, n := binary.Uvarint(data)
+				if n <= 0 {
+					return errors.New("invalid packed blocksizes")
+				}
+				fs.BlockSizes = append(fs.BlockSizes, size)
+				data = data[n:]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &fs, nil
+}
+
+// decodeFields calls f with the varint value or the bytes of every field in the protobuf message
+func decodeFields(buf []byte, f func(field int, v uint64, data []byte) error) error {
+	for len(buf) > 0 {
+		key, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return errors.New("invalid protobuf field key")
+		}
+		buf = buf[n:]
+		field, wireType := int(key>>3), key&7
+
+		var (
+			v    uint64
+			data []byte
+		)
+		switch wireType {
+		case 0:
+			if v, n = binary.Uvarint(buf); n <= 0 {
+				return errors.New("invalid protobuf varint")
+			}
+			buf = buf[n:]
+		case 1, 5:
+			size := 8
+			if wireType == 5 {
+				size = 4
+			}
+			if len(buf) < size {
+				return io.ErrUnexpectedEOF
+			}
+			buf = buf[size:]
+			continue
+		case 2:
+			length, n := binary.Uvarint(buf)
+			if n <= 0 || uint64(len(buf)-n) < length {
+				return errors.New("invalid protobuf length")
+			}
+			data = buf[n : n+int(length)]
+			if data == nil {
+				data = []byte{}
+			}
+			buf = buf[n+int(length):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", wireType)
+		}
+		if err := f(field, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+package client
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func boolPtr(v bool) *bool {
+	return &v
+}
+
+// testParams returns the ipfs add defaults changed by f
+func testParams(f func(p *unixfsParams)) unixfsParams {
+	params := defaultUnixfsParams()
+	if f != nil {
+		f(&params)
+	}
+	return params
+}
+
+func TestDagBuilderFileCid(t *testing.T) {
+	v0 := defaultUnixfsParams()
+	v1 := testParams(func(p *unixfsParams) { p.CidVersion, p.RawLeaves = 1, true })
+	tests := []struct {
+		name   string
+		params unixfsParams
+		data   string
+		want   string
+	}{
+		{"empty v0", v0, "", "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH"},
+		{"hello world v0", v0, "hello world", "Qmf412jQZiuVUtdgnB36FXFX7xg5V6KEbSJ4dpQuhkLyfD"},
+		{"hello world newline v0", v0, "hello world\n", "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"},
+		{"empty v1", v1, "", "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"},
+		{"hello world v1", v1, "hello world", "bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := newDagBuilder(tt.params).file(strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if node.Cid.String() != tt.want {
+				t.Errorf("cid = %s, want %s", node.Cid, tt.want)
+			}
+			if node.FileSize != uint64(len(tt.data)) {
+				t.Errorf("file size = %d, want %d", node.FileSize, len(tt.data))
+			}
+		})
+	}
+}
+
+func TestDagBuilderEmptyDirectoryCid(t *testing.T) {
+	tests := []struct {
+		version int
+		want    string
+	}{
+		{0, "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn"},
+		{1, "bafybeiczsscdsbs7ffqz55asqdf3smv6klcw3gofszvwlyarci47bgf354"},
+	}
+	for _, tt := range tests {
+		node, err := newDagBuilder(testParams(func(p *unixfsParams) { p.CidVersion = tt.version })).directory(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node.Cid.String() != tt.want {
+			t.Errorf("v%d: cid = %s, want %s", tt.version, node.Cid, tt.want)
+		}
+	}
+}
+
+func TestDagBuilderBalancedLayout(t *testing.T) {
+	blocks := make(map[cid.Cid][]byte)
+	b := newDagBuilder(testParams(func(p *unixfsParams) { p.ChunkSize, p.MaxLinks = 4, 2 }))
+	b.put = func(c cid.Cid, block []byte) error {
+		blocks[c] = block
+		return nil
+	}
+	root, err := b.file(strings.NewReader("0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// leaves of 4, 4 and 2 bytes: the first two fill a node, which is the first child of the root
+	node, err := decodePBNode(blocks[root.Cid])
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := decodeUnixfs(node.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs.Type != unixfsFile || fs.FileSize != 10 || !reflect.DeepEqual(fs.BlockSizes, []uint64{8, 2}) {
+		t.Errorf("root = type %d, size %d, block sizes %v", fs.Type, fs.FileSize, fs.BlockSizes)
+	}
+	if len(node.Links) != 2 {
+		t.Fatalf("root has %d links, want 2", len(node.Links))
+	}
+	tsize := uint64(len(blocks[root.Cid]))
+	for _, link := range node.Links {
+		tsize += link.Tsize
+	}
+	if root.Tsize != tsize {
+		t.Errorf("tsize = %d, want %d", root.Tsize, tsize)
+	}
+	if len(blocks) != 6 {
+		t.Errorf("built %d blocks, want 6", len(blocks))
+	}
+}
+
+func TestDagBuilderInline(t *testing.T) {
+	b := newDagBuilder(testParams(func(p *unixfsParams) {
+		p.CidVersion, p.RawLeaves, p.Inline, p.InlineLimit = 1, true, true, 8
+	}))
+	small, err := b.file(strings.NewReader("tiny"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if small.Cid.Prefix().MhType != mh.IDENTITY {
+		t.Errorf("small file is not inlined: %s", small.Cid)
+	}
+	large, err := b.file(strings.NewReader("not inlined"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if large.Cid.Prefix().MhType != mh.SHA2_256 {
+		t.Errorf("large file is inlined: %s", large.Cid)
+	}
+}
+
+func TestDagBuilderShardingThreshold(t *testing.T) {
+	file, err := newDagBuilder(defaultUnixfsParams()).file(strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every entry estimates 222 bytes of name and 34 bytes of cid v0
+	entries := func(n int) []dagLink {
+		links := make([]dagLink, n)
+		for i := range links {
+			links[i] = dagLink{Name: fmt.Sprintf("%0222d", i), Node: file}
+		}
+		return links
+	}
+
+	if _, err = newDagBuilder(defaultUnixfsParams()).directory(entries(shardingThreshold/256 - 1)); err != nil {
+		t.Errorf("directory below the threshold: %v", err)
+	}
+	if _, err = newDagBuilder(defaultUnixfsParams()).directory(entries(shardingThreshold / 256)); !errors.Is(err, ErrShardedDirectory) {
+		t.Errorf("directory at the threshold: err = %v, want %v", err, ErrShardedDirectory)
+	}
+}
+
+func TestDagBuilderTarMatchesPath(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	files := map[string]string{
+		"a.txt":       "a",
+		"sub/b.txt":   "bb",
+		"sub/c/d.txt": strings.Repeat("d", 1000),
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range []string{"root", "root/sub", "root/sub/c"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name + "/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range files {
+		filePath := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: "root/" + name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	params := testParams(func(p *unixfsParams) { p.ChunkSize = 256 })
+	fromPath, err := newDagBuilder(params).addPath(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromTar, err := newDagBuilder(params).addTar(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fromPath.Cid.Equals(fromTar.Cid) {
+		t.Errorf("tar cid %s differs from path cid %s", fromTar.Cid, fromPath.Cid)
+	}
+}
+
+func TestUnixfsParamsFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		options UploadOptions
+		want    func(p *unixfsParams)
+		wantErr bool
+	}{
+		{name: "defaults"},
+		{name: "v1 raw leaves", options: UploadOptions{CidVersion: intPtr(1)}, want: func(p *unixfsParams) {
+			p.CidVersion, p.RawLeaves = 1, true
+		}},
+		{name: "v1 without raw leaves", options: UploadOptions{CidVersion: intPtr(1), RawLeaves: boolPtr(false)}, want: func(p *unixfsParams) {
+			p.CidVersion = 1
+		}},
+		{name: "hash upgrades to v1", options: UploadOptions{Hash: "blake2b-256"}, want: func(p *unixfsParams) {
+			p.CidVersion, p.RawLeaves, p.HashFunc = 1, true, mh.Names["blake2b-256"]
+		}},
+		{name: "explicit v0 with another hash", options: UploadOptions{CidVersion: intPtr(0), Hash: "blake2b-256"}, wantErr: true},
+		{name: "unknown hash", options: UploadOptions{Hash: "md4"}, wantErr: true},
+		{name: "unknown cid version", options: UploadOptions{CidVersion: intPtr(2)}, wantErr: true},
+		{name: "size chunker", options: UploadOptions{Chunker: "size-1024"}, want: func(p *unixfsParams) {
+			p.ChunkSize = 1024
+		}},
+		{name: "oversize chunker", options: UploadOptions{Chunker: "size-2097152"}, wantErr: true},
+		{name: "rabin chunker", options: UploadOptions{Chunker: "rabin"}, wantErr: true},
+		{name: "trickle", options: UploadOptions{Trickle: true}, wantErr: true},
+		{name: "inline", options: UploadOptions{Inline: true, InlineLimit: 16}, want: func(p *unixfsParams) {
+			p.Inline, p.InlineLimit = true, 16
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := unixfsParamsFor(tt.options)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("no error, params %+v", params)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := testParams(tt.want); params != want {
+				t.Errorf("params = %+v, want %+v", params, want)
+			}
+		})
+	}
+}
+
+func TestUnixfsParamsOf(t *testing.T) {
+	tests := []struct {
+		cid  string
+		want unixfsParams
+	}{
+		{"QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH", defaultUnixfsParams()},
+		{"bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku", testParams(func(p *unixfsParams) {
+			p.CidVersion, p.RawLeaves = 1, true
+		})},
+	}
+	for _, tt := range tests {
+		if got := unixfsParamsOf(cid.MustParse(tt.cid)); got != tt.want {
+			t.Errorf("%s: params = %+v, want %+v", tt.cid, got, tt.want)
+		}
+	}
+}
+
+func TestPBNodeRoundTrip(t *testing.T) {
+	child := cid.MustParse("QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH")
+	size := uint64(300)
+	data := encodeUnixfs(unixfsFile, []byte("payload"), &size, []uint64{100, 200})
+	block := encodePBNode([]dagLink{
+		{Name: "first", Node: dagNode{Cid: child, Tsize: 6}},
+		{Name: "", Node: dagNode{Cid: child, Tsize: 7}},
+	}, data)
+
+	node, err := decodePBNode(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLinks := []pbLink{{Hash: child, Name: "first", Tsize: 6}, {Hash: child, Tsize: 7}}
+	if !reflect.DeepEqual(node.Links, wantLinks) {
+		t.Errorf("links = %+v, want %+v", node.Links, wantLinks)
+	}
+	fs, err := decodeUnixfs(node.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &unixfsData{Type: unixfsFile, Data: []byte("payload"), FileSize: 300, BlockSizes: []uint64{100, 200}}
+	if !reflect.DeepEqual(fs, want) {
+		t.Errorf("unixfs = %+v, want %+v", fs, want)
+	}
+}
+
+func TestDecodePBNodeInvalid(t *testing.T) {
+	tests := map[string][]byte{
+		"truncated length":   {1<<3 | 2, 10, 'x'},
+		"truncated key":      {0x80},
+		"link without hash":  {2<<3 | 2, 2, 2<<3 | 2, 0},
+		"unsupported wire":   {1<<3 | 3},
+		"invalid link cid":   {2<<3 | 2, 3, 1<<3 | 2, 1, 0xff},
+		"truncated fixed64":  {1<<3 | 1, 0, 0},
+		"truncated varint":   {1<<3 | 0, 0x80},
+		"overflowing length": {1<<3 | 2, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+	}
+	for name, block := range tests {
+		if _, err := decodePBNode(block); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
@@ -9,10 +9,10 @@ import (
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
 
 	shell "github.com/ipfs/go-ipfs-api"
+	files "github.com/ipfs/go-ipfs-files"
 )
 
 func PathJoin(url string, parts ...string) string {
@@ -22,10 +22,29 @@ func PathJoin(url string, parts ...string) string {
 	return strings.TrimRight(url, "/")
 }
 
+// GetIpfsCidInfo returns the size and type of the ipfsCid, failing over to the next ipfs api of MetaConf
+func (m *MetaClient) GetIpfsCidInfo(ipfsCid string) (IpfsCidInfo, error) {
+	return m.GetIpfsCidInfoContext(context.Background(), ipfsCid)
+}
+
+// GetIpfsCidInfoContext is like GetIpfsCidInfo but binds the ipfs requests to ctx
+func (m *MetaClient) GetIpfsCidInfoContext(ctx context.Context, ipfsCid string) (info IpfsCidInfo, err error) {
+	err = m.withIpfsFailover(ctx, func(node *ipfsNode) error {
+		info, err = GetIpfsCidInfoContext(ctx, node.api, ipfsCid)
+		return err
+	})
+	return
+}
+
 func GetIpfsCidInfo(ipfsApiUrl string, ipfsCid string) (IpfsCidInfo, error) {
+	return GetIpfsCidInfoContext(context.Background(), ipfsApiUrl, ipfsCid)
+}
+
+// GetIpfsCidInfoContext is like GetIpfsCidInfo but binds the ipfs request to ctx
+func GetIpfsCidInfoContext(ctx context.Context, ipfsApiUrl string, ipfsCid string) (IpfsCidInfo, error) {
 	info := IpfsCidInfo{IpfsCid: ipfsCid}
 	sh := shell.NewShell(ipfsApiUrl)
-	stat, err := sh.FilesStat(context.Background(), PathJoin("/ipfs/", ipfsCid))
+	stat, err := sh.FilesStat(ctx, PathJoin("/ipfs/", ipfsCid))
 	if err != nil {
 		return info, err
 	}
@@ -38,37 +57,37 @@ func GetIpfsCidInfo(ipfsApiUrl string, ipfsCid string) (IpfsCidInfo, error) {
 	return info, nil
 }
 
-func downloadFileByAria2(conf *Aria2Conf, downUrl, outPath string) error {
-	aria2 := NewAria2Client(conf.Host, conf.Secret, conf.Port)
-	outDir := filepath.Dir(outPath)
-	fileName := filepath.Base(outPath)
-	aria2Download := aria2.DownloadFile(downUrl, outDir, fileName)
-	if aria2Download == nil {
-		return errors.New("no response when asking aria2 to download")
-	}
+const (
+	contentTypeForm = "application/x-www-form-urlencoded"
+	contentTypeJson = "application/json; charset=UTF-8"
+)
 
-	if aria2Download.Error != nil {
-		return errors.New(aria2Download.Error.Message)
-	}
+// httpStatusError is returned by the http helpers when the response status is not 200
+type httpStatusError struct {
+	Status     string
+	StatusCode int
+	Url        string
+	Body       []byte
+}
 
-	if aria2Download.Gid == "" {
-		return errors.New("no gid returned when asking aria2 to download")
-	}
+// maxErrorBodySize limits how much of a failed response body is kept in httpStatusError
+const maxErrorBodySize = 64 << 10
 
-	return nil
+func newHttpStatusError(response *http.Response, uri string) *httpStatusError {
+	body, _ := io.ReadAll(io.LimitReader(response.Body, maxErrorBodySize))
+	return &httpStatusError{Status: response.Status, StatusCode: response.StatusCode, Url: uri, Body: body}
 }
 
-const (
-	contentTypeForm = "application/x-www-form-urlencoded"
-	contentTypeJson = "application/json; charset=UTF-8"
-)
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("http status: %s, code:%d, url:%s", e.Status, e.StatusCode, e.Url)
+}
 
-func httpRequestWithKey(httpMethod, uri, key, token string, params interface{}) (body []byte, err error) {
+func httpRequestWithKey(ctx context.Context, httpMethod, uri, key, token string, params interface{}) (body []byte, err error) {
 	var request *http.Request
 
 	switch params := params.(type) {
 	case io.Reader:
-		request, err = http.NewRequest(httpMethod, uri, params)
+		request, err = http.NewRequestWithContext(ctx, httpMethod, uri, params)
 		if err != nil {
 			return nil, err
 		}
@@ -79,7 +98,7 @@ func httpRequestWithKey(httpMethod, uri, key, token string, params interface{})
 			return nil, errJson
 		}
 
-		request, err = http.NewRequest(httpMethod, uri, bytes.NewBuffer(jsonReq))
+		request, err = http.NewRequestWithContext(ctx, httpMethod, uri, bytes.NewBuffer(jsonReq))
 		if err != nil {
 			return nil, err
 		}
@@ -102,38 +121,125 @@ func httpRequestWithKey(httpMethod, uri, key, token string, params interface{})
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
-		err := fmt.Errorf("http status: %s, code:%d, url:%s", response.Status, response.StatusCode, uri)
-		return nil, err
+		return nil, newHttpStatusError(response, uri)
 	}
 	return io.ReadAll(response.Body)
 }
 
-func uploadFileToIpfs(sh *shell.Shell, fileName string) (string, error) {
+func uploadFileToIpfs(ctx context.Context, sh *shell.Shell, fileName string, opt *uploadOption, progress *uploadProgress) (string, error) {
 	file, err := os.Open(fileName)
 	if err != nil {
 		return "", err
 	}
 	defer file.Close()
 
-	ipfsCid, err := sh.Add(file)
-	if err != nil {
-		return "", err
-	}
+	return uploadNodeToIpfs(ctx, sh, "", files.NewReaderFile(file), opt, progress)
+}
 
-	if err = sh.FilesCp(context.Background(), PathJoin("/ipfs/", ipfsCid), "/"); err != nil {
-		return "", err
+func uploadDirToIpfs(ctx context.Context, sh *shell.Shell, tree *uploadEntry, opt *uploadOption, progress *uploadProgress) (string, error) {
+	return uploadNodeToIpfs(ctx, sh, tree.name, &entryDirectory{entry: tree}, opt, progress, addRecursive)
+}
+
+// uploadNodeToIpfs adds the node with the upload options without pinning it, placeInMfs pins it
+func uploadNodeToIpfs(ctx context.Context, sh *shell.Shell, name string, node files.Node, opt *uploadOption, progress *uploadProgress, options ...shell.AddOpts) (string, error) {
+	options = append(addOptions(opt.Add), options...)
+	options = append(options, shell.Pin(false))
+	return addToIpfs(ctx, sh, name, node, progress, options...)
+}
+
+// addOptions converts the upload options to the `ipfs add` options, the unset ones are left to ipfs
+func addOptions(o UploadOptions) []shell.AddOpts {
+	var opts []shell.AddOpts
+	if o.CidVersion != nil {
+		opts = append(opts, shell.CidVersion(*o.CidVersion))
+	}
+	if o.RawLeaves != nil {
+		opts = append(opts, shell.RawLeaves(*o.RawLeaves))
 	}
-	return ipfsCid, nil
+	if o.Hash != "" {
+		opts = append(opts, shell.Hash(o.Hash))
+	}
+	if o.OnlyHash {
+		opts = append(opts, shell.OnlyHash(true))
+	}
+	opts = append(opts, func(rb *shell.RequestBuilder) error {
+		if o.Chunker != "" {
+			rb.Option("chunker", o.Chunker)
+		}
+		if o.Trickle {
+			rb.Option("trickle", true)
+		}
+		if o.Inline {
+			rb.Option("inline", true)
+		}
+		if o.InlineLimit > 0 {
+			rb.Option("inline-limit", o.InlineLimit)
+		}
+		return nil
+	})
+	return opts
 }
 
-func uploadDirToIpfs(sh *shell.Shell, dirName string) (string, error) {
-	ipfsCid, err := sh.AddDir(dirName)
+func addRecursive(rb *shell.RequestBuilder) error {
+	rb.Option("recursive", true)
+	return nil
+}
+
+// addToIpfs adds the node to ipfs like shell.Add and shell.AddDir do, but binds the request to ctx.
+// ipfs streams back one object for each added entry, the last one is the root,
+// and the bytes added so far of the current file if progress is not nil.
+func addToIpfs(ctx context.Context, sh *shell.Shell, name string, node files.Node, progress *uploadProgress, options ...shell.AddOpts) (string, error) {
+	slf := files.NewSliceDirectory([]files.DirEntry{files.FileEntry(name, node)})
+	reader := files.NewMultiFileReader(slf, true)
+
+	rb := sh.Request("add")
+	if progress != nil {
+		rb.Option("progress", true)
+	}
+	for _, option := range options {
+		if err := option(rb); err != nil {
+			return "", err
+		}
+	}
+
+	resp, err := rb.Body(reader).Send(ctx)
 	if err != nil {
 		return "", err
 	}
+	defer resp.Close()
 
-	if err = sh.FilesCp(context.Background(), PathJoin("/ipfs/", ipfsCid), "/"); err != nil {
-		return "", err
+	if resp.Error != nil {
+		return "", resp.Error
+	}
+
+	dec := json.NewDecoder(resp.Output)
+	var final string
+	for {
+		var out struct {
+			Name  string
+			Hash  string
+			Bytes int64
+		}
+		if err = dec.Decode(&out); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return "", err
+		}
+		if out.Hash == "" {
+			if progress != nil {
+				progress.bytes(out.Name, out.Bytes)
+			}
+			continue
+		}
+		if progress != nil {
+			progress.added(out.Name)
+		}
+		final = out.Hash
+	}
+
+	if final == "" {
+		return "", errors.New("no results received from ipfs")
 	}
-	return ipfsCid, nil
+	return final, nil
 }
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ipfs/go-cid"
+)
+
+var ErrCidMismatch = errors.New("cid mismatch")
+
+// CidMismatchError is returned when the downloaded content does not match the ipfs cid
+type CidMismatchError struct {
+	Path     string // the downloaded file or directory
+	Expected string // the ipfs cid asked for
+	Actual   string // the cid computed from the downloaded content
+	Guessed  bool   // the upload parameters are guessed from the cid, the content may be added with other ones
+}
+
+func (e *CidMismatchError) Error() string {
+	msg := fmt.Sprintf("cid mismatch of %s, expected %s, but got %s", e.Path, e.Expected, e.Actual)
+	if e.Guessed {
+		msg += ", the upload parameters are guessed from the cid"
+	}
+	return msg
+}
+
+func (e *CidMismatchError) Is(target error) bool {
+	return target == ErrCidMismatch
+}
+
+// VerifyCid checks that the file or directory at filePath was added as ipfsCid.
+// If isTar is true, filePath is the tar packed by the gateway with ?format=tar.
+// The content is re-chunked with the UploadOptions it was uploaded with, if they are not given
+// they are guessed from the cid with the ipfs add defaults, cid v1 implies raw leaves like ipfs add does.
+func VerifyCid(ipfsCid, filePath string, isTar bool, uploadOptions ...UploadOptions) error {
+	expected, err := cid.Decode(ipfsCid)
+	if err != nil {
+		return err
+	}
+	params, guessed, err := verifyParams(expected, uploadOptions)
+	if err != nil {
+		return err
+	}
+
+	node, err := buildDownloaded(newDagBuilder(params), filePath, isTar)
+	if err != nil {
+		return err
+	}
+
+	if !node.Cid.Equals(expected) {
+		return &CidMismatchError{
+			Path:     filePath,
+			Expected: expected.String(),
+			Actual:   node.Cid.String(),
+			Guessed:  guessed,
+		}
+	}
+	return nil
+}
+
+// verifyParams returns the parameters to re-chunk the content with, guessed is true
+// if no UploadOptions is given and they are guessed from the cid
+func verifyParams(expected cid.Cid, uploadOptions []UploadOptions) (params unixfsParams, guessed bool, err error) {
+	if len(uploadOptions) == 0 {
+		return unixfsParamsOf(expected), true, nil
+	}
+	params, err = unixfsParamsFor(uploadOptions[0])
+	return params, false, err
+}
+
+func buildDownloaded(b *dagBuilder, filePath string, isTar bool) (dagNode, error) {
+	if !isTar {
+		return b.addPath(filePath)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return dagNode{}, err
+	}
+	defer file.Close()
+	return b.addTar(file)
+}
+
+// verifyDownload verifies the downloaded file and removes it if the content does not match,
+// unless the upload parameters are guessed, then the content may be correct
+func verifyDownload(ipfsCid, downloadFile string, isTar bool, uploadOptions []UploadOptions) error {
+	err := VerifyCid(ipfsCid, downloadFile, isTar, uploadOptions...)
+	var mismatch *CidMismatchError
+	if errors.As(err, &mismatch) && !mismatch.Guessed {
+		os.RemoveAll(downloadFile)
+	}
+	return err
+}
@@ -44,7 +44,9 @@ func main() {
 	outPath := "./output"
 	downloadUrl := "http://127.0.0.1:8080/ipfs/QmQgM2tGEduvYmgYy54jZaZ9D7qtsNETcog8EHR8XoeyEp"
 
-	err = metaClient.Download(ipfsData.IpfsCid, outPath, downloadUrl)
+	err = metaClient.DownloadWithOptions(ipfsData.IpfsCid, outPath, metacli.WithDownloadUrl(downloadUrl), metacli.WithProgress(func(p metacli.DownloadProgress) {
+		log.Printf("downloading %d/%d bytes, speed: %d B/s", p.CompletedLength, p.TotalLength, p.DownloadSpeed)
+	}))
 	if err != nil {
 		log.Println("download failed:", err)
 		return